@@ -22,6 +22,8 @@ export type CreateNewOrderResult = {
   }>;
   frameItems: OrderFrameItemsResponse<FrameExtras>[];
   paperweightItem: OrderPaperweightItemsResponse | null;
+  // set when the order's occasion lands in an overbooked workshop week
+  capacityWarning: string | null;
 };
 
 export const createNewOrder = async (
@@ -81,7 +83,7 @@ export const createNewOrder = async (
 
   const order = await pb
     .collection(COLLECTIONS.ORDERS)
-    .create<OrdersResponse>(orderPayload);
+    .create<OrdersResponse & { capacityWarning?: string }>(orderPayload);
 
   const customerPayload = {
     firstName: values.firstName,
@@ -111,5 +113,6 @@ export const createNewOrder = async (
     order: expandedOrder,
     frameItems,
     paperweightItem,
+    capacityWarning: order.capacityWarning ?? null,
   };
 };
@@ -168,6 +168,9 @@ export const normalisedCustomer = ({
   expand,
   telephone: phoneNumber,
   email,
+  emailBounced,
+  emailBouncedAt,
+  emailBounceReason,
   howRecommended,
   id,
   collectionId: colId,
@@ -183,6 +186,9 @@ export const normalisedCustomer = ({
     customerId: id,
     colId,
     email,
+    emailBounced: Boolean(emailBounced),
+    emailBouncedAt: emailBouncedAt || "",
+    emailBounceReason: emailBounceReason || "",
     orderDetails,
     howRecommended,
     title,
@@ -56,8 +56,12 @@ const CreateNewOrderModal: FC<CreateNewOrderModalProps> = ({
 
   const { mutateAsync: mutateCreateOrder, isPending } = useMutation({
     mutationFn: (values: CreateOrderFormValues) => createNewOrder(values),
-    onSuccess: () => {
+    onSuccess: (result) => {
       setSubmitError(null);
+      // the order is saved either way; the warning is only for the user to act on
+      if (result.capacityWarning) {
+        window.alert(result.capacityWarning);
+      }
       queryClient.invalidateQueries({ queryKey: ["customers"] });
       if (currentCustomerForm?.orderId) {
         queryClient.invalidateQueries({
@@ -316,6 +316,25 @@ const OrderPage = () => {
         onOpenEmailActions={() => setIsEmailDrawerOpen(true)}
         previewDisabled={!order?.orderId}
       />
+      {customer.emailBounced && (
+        <Box
+          mb="3"
+          style={{
+            borderRadius: 8,
+            border: "1px solid var(--red-6)",
+            backgroundColor: "var(--red-3)",
+            padding: 8,
+          }}
+        >
+          <Text size="2" color="red" weight="bold">
+            Emails to {customer.email} are bouncing
+            {customer.emailBounceReason
+              ? ` (${customer.emailBounceReason})`
+              : ""}
+            . Check the address before sending more.
+          </Text>
+        </Box>
+      )}
       <OrderActionsBar
         created={order?.created}
         occasionDate={order?.occasionDate}
@@ -113,6 +113,9 @@ export type CustomersHowRecommendedOptions =
 export type CustomersRecord = {
   created: IsoAutoDateString;
   email: string;
+  emailBounceReason?: string;
+  emailBounced?: boolean;
+  emailBouncedAt?: IsoDateString;
   firstName: string;
   howRecommended?: CustomersHowRecommendedOptions;
   id: string;
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	defaultWorkshopCapacityHours = 40
+	defaultFrameHours3D          = 6
+	defaultFrameHoursPressed     = 4
+
+	// frames are weighted by area against a 12x16in frame
+	referenceFrameArea = 12 * 16
+)
+
+// capacityWarningKey is the custom field carrying the warning on the order create response.
+const capacityWarningKey = "capacityWarning"
+
+// capacitySettings is the workshop's weekly capacity and the hours a frame is expected to take.
+type capacitySettings struct {
+	WeeklyHours  float64
+	Hours3D      float64
+	HoursPressed float64
+}
+
+// resolveCapacitySettings reads WORKSHOP_CAPACITY_HOURS (per week, default 40) and
+// WORKSHOP_FRAME_HOURS_3D / WORKSHOP_FRAME_HOURS_PRESSED (a 12x16in frame, default 6 and 4).
+func resolveCapacitySettings(app core.App) capacitySettings {
+	hours := func(name string, fallback float64) float64 {
+		raw := strings.TrimSpace(os.Getenv(name))
+		if raw == "" {
+			return fallback
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value <= 0 {
+			app.Logger().Warn("invalid workshop hours, using default", "name", name, "value", raw)
+			return fallback
+		}
+		return value
+	}
+
+	return capacitySettings{
+		WeeklyHours:  hours("WORKSHOP_CAPACITY_HOURS", defaultWorkshopCapacityHours),
+		Hours3D:      hours("WORKSHOP_FRAME_HOURS_3D", defaultFrameHours3D),
+		HoursPressed: hours("WORKSHOP_FRAME_HOURS_PRESSED", defaultFrameHoursPressed),
+	}
+}
+
+// frameHours is the work left on a frame: nothing once it is framed, otherwise the hours for
+// its preservationType scaled by its size. Frames without a usable size count as 12x16in.
+func (s capacitySettings) frameHours(frame *core.Record) float64 {
+	if frameStageIndex(frame.GetString("stage")) >= frameStageIndex("framed") {
+		return 0
+	}
+
+	hours := s.HoursPressed
+	if frame.GetString("preservationType") == "3D" {
+		hours = s.Hours3D
+	}
+
+	sizeX, okX := coerceFloat(frame.GetString("sizeX"))
+	sizeY, okY := coerceFloat(frame.GetString("sizeY"))
+	if okX && okY && sizeX > 0 && sizeY > 0 {
+		// small frames still need the preservation work, so they never count less than half
+		hours *= math.Max(sizeX*sizeY/referenceFrameArea, 0.5)
+	}
+	return hours
+}
+
+// orderHours is the work left on an order: its unframed frames plus the order's artistHours,
+// which only count while some frame is still unframed.
+func (s capacitySettings) orderHours(order *core.Record, frames []*core.Record) (float64, int) {
+	total := 0.0
+	unframed := 0
+	for _, frame := range frames {
+		if hours := s.frameHours(frame); hours > 0 {
+			total += hours
+			unframed++
+		}
+	}
+	if unframed > 0 {
+		total += math.Max(order.GetFloat("artistHours"), 0)
+	}
+	return total, unframed
+}
+
+// capacityOrder is one order's share of a week.
+type capacityOrder struct {
+	OrderId      string  `json:"orderId"`
+	OrderNo      int     `json:"orderNo"`
+	OrderRef     string  `json:"orderRef"`
+	OccasionDate string  `json:"occasionDate"`
+	Frames       int     `json:"frames"`
+	Hours        float64 `json:"hours"`
+}
+
+// capacityWeek is the work booked into one week (Monday to Sunday, UTC).
+type capacityWeek struct {
+	WeekStart  string          `json:"weekStart"`
+	WeekEnd    string          `json:"weekEnd"`
+	Hours      float64         `json:"hours"`
+	Capacity   float64         `json:"capacity"`
+	Frames     int             `json:"frames"`
+	Overbooked bool            `json:"overbooked"`
+	Orders     []capacityOrder `json:"orders"`
+}
+
+// workshopWeekStart is the Monday of the week containing at.
+func workshopWeekStart(at time.Time) time.Time {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// openOrdersFilter matches the orders whose unframed work still counts against the workshop.
+const openOrdersFilter = `occasionDate != "" && orderStatus != "delivered" && orderStatus != "cancelled"`
+
+// projectWorkshopLoad books the unframed work on every open order into the week of its
+// occasion, which is when the flowers come in. Orders without an occasionDate are left out.
+func projectWorkshopLoad(app core.App, settings capacitySettings) (map[time.Time]*capacityWeek, error) {
+	orders, err := app.FindRecordsByFilter("orders", openOrdersFilter, "occasionDate", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return bookWorkshopLoad(app, settings, orders)
+}
+
+// projectWorkshopWeekLoad is projectWorkshopLoad for the single week starting at weekStart.
+func projectWorkshopWeekLoad(app core.App, settings capacitySettings, weekStart time.Time) (map[time.Time]*capacityWeek, error) {
+	orders, err := app.FindRecordsByFilter(
+		"orders",
+		openOrdersFilter+" && occasionDate >= {:from} && occasionDate < {:to}",
+		"occasionDate",
+		0,
+		0,
+		dbx.Params{
+			"from": weekStart.Format(types.DefaultDateLayout),
+			"to":   weekStart.AddDate(0, 0, 7).Format(types.DefaultDateLayout),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return bookWorkshopLoad(app, settings, orders)
+}
+
+func bookWorkshopLoad(app core.App, settings capacitySettings, orders []*core.Record) (map[time.Time]*capacityWeek, error) {
+	frameIds := []string{}
+	for _, order := range orders {
+		frameIds = append(frameIds, order.GetStringSlice("frameOrderId")...)
+	}
+	frames, err := fetchRecordsByIds(app, "order_frame_items", frameIds)
+	if err != nil {
+		return nil, err
+	}
+	frameById := make(map[string]*core.Record, len(frames))
+	for _, frame := range frames {
+		frameById[frame.Id] = frame
+	}
+
+	weeks := map[time.Time]*capacityWeek{}
+	for _, order := range orders {
+		orderFrames := []*core.Record{}
+		for _, frameId := range order.GetStringSlice("frameOrderId") {
+			if frame, ok := frameById[frameId]; ok {
+				orderFrames = append(orderFrames, frame)
+			}
+		}
+		bookOrderHours(weeks, settings, order, orderFrames)
+	}
+	return weeks, nil
+}
+
+func bookOrderHours(weeks map[time.Time]*capacityWeek, settings capacitySettings, order *core.Record, frames []*core.Record) *capacityWeek {
+	hours, unframed := settings.orderHours(order, frames)
+	if hours == 0 {
+		return nil
+	}
+
+	start := workshopWeekStart(order.GetDateTime("occasionDate").Time())
+	week, ok := weeks[start]
+	if !ok {
+		week = &capacityWeek{
+			WeekStart: start.Format("2006-01-02"),
+			WeekEnd:   start.AddDate(0, 0, 6).Format("2006-01-02"),
+			Capacity:  settings.WeeklyHours,
+			Orders:    []capacityOrder{},
+		}
+		weeks[start] = week
+	}
+
+	week.Hours = roundHours(week.Hours + hours)
+	week.Frames += unframed
+	week.Overbooked = week.Hours > week.Capacity
+	week.Orders = append(week.Orders, capacityOrder{
+		OrderId:      order.Id,
+		OrderNo:      order.GetInt("orderNo"),
+		OrderRef:     order.GetString("orderRef"),
+		OccasionDate: order.GetString("occasionDate"),
+		Frames:       unframed,
+		Hours:        roundHours(hours),
+	})
+	return week
+}
+
+func roundHours(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+// capacityWarningForOrder says so when the new order's occasion lands in a week that, with this
+// order booked in, is over capacity. It is empty otherwise.
+func capacityWarningForOrder(app core.App, order *core.Record) (string, error) {
+	if order.GetDateTime("occasionDate").IsZero() {
+		return "", nil
+	}
+
+	// only the orders in the new order's week can push it over
+	settings := resolveCapacitySettings(app)
+	weekStart := workshopWeekStart(order.GetDateTime("occasionDate").Time())
+	weeks, err := projectWorkshopWeekLoad(app, settings, weekStart)
+	if err != nil {
+		return "", err
+	}
+
+	frames, err := fetchRecordsByIds(app, "order_frame_items", order.GetStringSlice("frameOrderId"))
+	if err != nil {
+		return "", err
+	}
+	week := bookOrderHours(weeks, settings, order, frames)
+	if week == nil || !week.Overbooked {
+		return "", nil
+	}
+
+	start, _ := time.Parse("2006-01-02", week.WeekStart)
+	return fmt.Sprintf(
+		"The week of %s is overbooked: %s of %s workshop hours booked.",
+		start.Format("2 Jan 2006"),
+		strconv.FormatFloat(week.Hours, 'f', -1, 64),
+		strconv.FormatFloat(week.Capacity, 'f', -1, 64),
+	), nil
+}
+
+// registerCapacityHooks adds capacityWarning to the create response of an order whose occasion
+// falls in an overbooked week. The order is still created.
+func registerCapacityHooks(app *pocketbase.PocketBase) {
+	app.OnRecordCreateRequest("orders").BindFunc(func(e *core.RecordRequestEvent) error {
+		warning, err := capacityWarningForOrder(e.App, e.Record)
+		if err != nil {
+			e.App.Logger().Error("capacity check failed", "error", err.Error())
+		}
+		if warning != "" {
+			e.Record.Set(capacityWarningKey, warning)
+			e.Record.WithCustomData(true)
+		}
+		return e.Next()
+	})
+}
+
+// capacityWeeks lists count weeks from the week containing from, empty ones included.
+func capacityWeeks(weeks map[time.Time]*capacityWeek, settings capacitySettings, from time.Time, count int) []*capacityWeek {
+	result := make([]*capacityWeek, 0, count)
+	start := workshopWeekStart(from)
+	for i := 0; i < count; i++ {
+		weekStart := start.AddDate(0, 0, 7*i)
+		week, ok := weeks[weekStart]
+		if !ok {
+			week = &capacityWeek{
+				WeekStart: weekStart.Format("2006-01-02"),
+				WeekEnd:   weekStart.AddDate(0, 0, 6).Format("2006-01-02"),
+				Capacity:  settings.WeeklyHours,
+				Orders:    []capacityOrder{},
+			}
+		}
+		sort.SliceStable(week.Orders, func(a, b int) bool {
+			return week.Orders[a].OccasionDate < week.Orders[b].OccasionDate
+		})
+		result = append(result, week)
+	}
+	return result
+}
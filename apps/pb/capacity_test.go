@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func TestFrameHours(t *testing.T) {
+	settings := capacitySettings{WeeklyHours: 40, Hours3D: 6, HoursPressed: 4}
+	app := newTestApp(t, registerOrderStatusHooks, registerFrameStageHooks)
+
+	scenarios := []struct {
+		name     string
+		values   map[string]any
+		expected float64
+	}{
+		{"pressed without size", map[string]any{"preservationType": "pressed"}, 4},
+		{"3D at the reference size", map[string]any{"preservationType": "3D", "sizeX": "12", "sizeY": "16"}, 6},
+		{"3D twice the area", map[string]any{"preservationType": "3D", "sizeX": "16", "sizeY": "24"}, 12},
+		{"small frames count at least half", map[string]any{"sizeX": "4", "sizeY": "6"}, 2},
+		{"framed frames are done", map[string]any{"preservationType": "3D", "stage": "framed"}, 0},
+	}
+
+	for _, s := range scenarios {
+		frame := createTestFrame(t, app, s.values)
+		if got := settings.frameHours(frame); got != s.expected {
+			t.Errorf("%s: expected %v hours, got %v", s.name, s.expected, got)
+		}
+	}
+}
+
+func TestWorkshopWeekStart(t *testing.T) {
+	scenarios := map[string]string{
+		"2026-06-01": "2026-06-01", // Monday
+		"2026-06-03": "2026-06-01",
+		"2026-06-07": "2026-06-01", // Sunday
+		"2026-06-08": "2026-06-08",
+	}
+
+	for day, expected := range scenarios {
+		at, _ := time.Parse("2006-01-02", day)
+		if got := workshopWeekStart(at).Format("2006-01-02"); got != expected {
+			t.Errorf("%s: expected %s, got %s", day, expected, got)
+		}
+	}
+}
+
+// createCapacityTestOrder books an order with frames of the given preservation types on occasionDate.
+func createCapacityTestOrder(t testing.TB, app core.App, occasionDate string, artistHours float64, types ...string) *core.Record {
+	frames := []*core.Record{}
+	for _, preservationType := range types {
+		frames = append(frames, createTestFrame(t, app, map[string]any{"preservationType": preservationType}))
+	}
+	order := createTestOrderWithFrames(t, app, frames...)
+	order.Set("occasionDate", occasionDate)
+	order.Set("artistHours", artistHours)
+	if err := app.Save(order); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestWorkshopCapacityApi(t *testing.T) {
+	t.Setenv("WORKSHOP_CAPACITY_HOURS", "20")
+
+	bookOrders := func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+		registerFrameStageHooks(&pocketbase.PocketBase{App: app})
+		registerWorkshopRoutes(e, &pocketbase.PocketBase{App: app})
+
+		// week of 1 June: 6+6+4 hours of frames plus 6 artist hours = 22
+		createCapacityTestOrder(t, app, "2026-06-03", 6, "3D", "3D", "pressed")
+		// week of 8 June: 4 hours
+		createCapacityTestOrder(t, app, "2026-06-13", 0, "pressed")
+		// before the range, so backlog
+		createCapacityTestOrder(t, app, "2026-05-20", 0, "3D")
+		// cancelled orders don't count
+		cancelled := createCapacityTestOrder(t, app, "2026-06-09", 0, "3D")
+		cancelled.Set("orderStatus", "cancelled")
+		if err := app.Save(cancelled); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "invalid weeks",
+			Method:          http.MethodGet,
+			URL:             "/api/workshop/capacity?from=2026-06-01&weeks=0",
+			Headers:         userAuthHeader(t),
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bookOrders,
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{"weeks must be between 1 and 52."},
+		},
+		{
+			Name:           "weekly load",
+			Method:         http.MethodGet,
+			URL:            "/api/workshop/capacity?from=2026-06-02&weeks=3",
+			Headers:        userAuthHeader(t),
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: bookOrders,
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"capacityHours":20`,
+				`"overbookedWeeks":["2026-06-01"]`,
+				`"backlog":{"frames":1,"hours":6}`,
+				`"weekStart":"2026-06-01","weekEnd":"2026-06-07","hours":22,"capacity":20,"frames":3,"overbooked":true`,
+				`"weekStart":"2026-06-08","weekEnd":"2026-06-14","hours":4,"capacity":20,"frames":1,"overbooked":false`,
+				`"weekStart":"2026-06-15","weekEnd":"2026-06-21","hours":0,"capacity":20,"frames":0,"overbooked":false,"orders":[]`,
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestProjectWorkshopWeekLoad(t *testing.T) {
+	app := newTestApp(t, registerOrderStatusHooks, registerFrameStageHooks)
+	settings := capacitySettings{WeeklyHours: 40, Hours3D: 6, HoursPressed: 4}
+
+	createCapacityTestOrder(t, app, "2026-05-31", 0, "3D")
+	inWeek := createCapacityTestOrder(t, app, "2026-06-07", 0, "pressed")
+	createCapacityTestOrder(t, app, "2026-06-08", 0, "3D")
+
+	weekStart, _ := time.Parse("2006-01-02", "2026-06-01")
+	weeks, err := projectWorkshopWeekLoad(app, settings, weekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(weeks) != 1 {
+		t.Fatalf("expected only the week of 1 June, got %d weeks", len(weeks))
+	}
+	week := weeks[weekStart]
+	if week == nil || len(week.Orders) != 1 || week.Orders[0].OrderId != inWeek.Id || week.Hours != 4 {
+		t.Fatalf("expected only the order on 7 June, got %+v", week)
+	}
+}
+
+func TestOrderCreateCapacityWarning(t *testing.T) {
+	t.Setenv("WORKSHOP_CAPACITY_HOURS", "10")
+
+	const newFrameId = "frame0000000001"
+	bookOrders := func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+		registerOrderStatusHooks(&pocketbase.PocketBase{App: app})
+		registerFrameStageHooks(&pocketbase.PocketBase{App: app})
+		registerCapacityHooks(&pocketbase.PocketBase{App: app})
+
+		// 6 hours booked into the week of 1 June
+		createCapacityTestOrder(t, app, "2026-06-03", 0, "3D")
+		createTestFrame(t, app, map[string]any{"id": newFrameId, "preservationType": "3D"})
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:               "week with room",
+			Method:             http.MethodPost,
+			URL:                "/api/collections/orders/records",
+			Body:               strings.NewReader(`{"occasionDate": "2026-06-10", "frameOrderId": ["` + newFrameId + `"]}`),
+			Headers:            userAuthHeader(t),
+			TestAppFactory:     newEmailTestApp,
+			BeforeTestFunc:     bookOrders,
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"occasionDate":"2026-06-10`},
+			NotExpectedContent: []string{capacityWarningKey, "@pbInternal"},
+		},
+		{
+			Name:            "overbooked week",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/orders/records",
+			Body:            strings.NewReader(`{"occasionDate": "2026-06-05", "frameOrderId": ["` + newFrameId + `"]}`),
+			Headers:         userAuthHeader(t),
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bookOrders,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"capacityWarning":"The week of 1 Jun 2026 is overbooked: 12 of 10 workshop hours booked."`},
+			// the status hooks' request details stay internal
+			NotExpectedContent: []string{"@pbInternal"},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				if total, _ := app.CountRecords("orders"); total != 2 {
+					t.Errorf("expected the order to be created anyway, got %d orders", total)
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
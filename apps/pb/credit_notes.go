@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+type creditNoteLinePayload struct {
+	Description string `json:"description"`
+	Amount      Number `json:"amount"` // ex VAT, same as invoice rows
+}
+
+type creditNoteRequest struct {
+	Reason string `json:"reason"`
+	// Lines are the amounts being credited. Empty means credit the whole invoice (eg cancelled order).
+	Lines []creditNoteLinePayload `json:"lines"`
+}
+
+func formatCreditNoteNo(sequence int) string {
+	return fmt.Sprintf("CN-%04d", sequence)
+}
+
+// creditedSoFar sums every credit note already issued for the order, against any version of
+// its invoice, so re-issuing the invoice doesn't make room for the same credit twice.
+func creditedSoFar(app core.App, orderId string) (float64, error) {
+	notes, err := app.FindRecordsByFilter(
+		"invoices",
+		`kind = "credit_note" && orderId = {:orderId}`,
+		"",
+		0,
+		0,
+		dbx.Params{"orderId": orderId},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0.0
+	for _, note := range notes {
+		total += note.GetFloat("grandTotal")
+	}
+	return roundMoney(total), nil
+}
+
+// nextCreditNoteSequence is the place the next credit note takes in the CN- numbering.
+func nextCreditNoteSequence(app core.App) (int, error) {
+	latest, err := app.FindRecordsByFilter("invoices", `kind = "credit_note"`, "-sequence", 1, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(latest) == 0 {
+		return 1, nil
+	}
+	return latest[0].GetInt("sequence") + 1, nil
+}
+
+// buildCreditNoteView derives the credit note from the original invoice snapshot so the
+// customer sees the same address/occasion details, with only the credited rows and totals.
+func buildCreditNoteView(original *core.Record, req creditNoteRequest) (invoiceViewModel, invoiceTotals, error) {
+	var view invoiceViewModel
+	if err := original.UnmarshalJSONField("snapshot", &view); err != nil {
+		return view, invoiceTotals{}, fmt.Errorf("invoice snapshot unreadable: %w", err)
+	}
+
+	vatRate := original.GetFloat("vatRate")
+	var totals invoiceTotals
+
+	if len(req.Lines) == 0 {
+		totals = invoiceTotals{
+			SubTotal:   original.GetFloat("subTotal"),
+			VatRate:    vatRate,
+			VatTotal:   original.GetFloat("vatTotal"),
+			GrandTotal: original.GetFloat("grandTotal"),
+		}
+	} else {
+		rows := make([]invoiceRow, 0, len(req.Lines))
+		amounts := make([]float64, 0, len(req.Lines))
+		for i, line := range req.Lines {
+			desc := strings.TrimSpace(line.Description)
+			amount := line.Amount.Float64()
+			if desc == "" || amount == nil || *amount <= 0 {
+				return view, invoiceTotals{}, fmt.Errorf("line %d needs a description and a positive amount", i+1)
+			}
+			rows = append(rows, invoiceRow{
+				ItemLabel:   fmt.Sprintf("Credit %d", i+1),
+				Description: desc,
+				Amount:      formatMoney(*amount),
+			})
+			amounts = append(amounts, *amount)
+		}
+		view.Rows = rows
+		totals = computeTotals(pricingInput{ExtrasPrices: amounts}, vatRate)
+	}
+
+	view.Title = "CREDIT NOTE"
+	view.DocumentNoLabel = "Credit Note No"
+	view.IsCreditNote = true
+	view.CreditedInvoiceNo = original.GetString("invoiceNo")
+	view.Reason = strings.TrimSpace(req.Reason)
+	view.InvoiceDate = formatDate(time.Now().Format("2006-01-02"))
+	view.SubTotal = formatMoney(totals.SubTotal)
+	view.VatTotal = formatMoney(totals.VatTotal)
+	view.GrandTotal = formatMoney(totals.GrandTotal)
+	view.Payments = nil
+	view.Credits = ""
+	view.BalanceDue = ""
+
+	return view, totals, nil
+}
+
+var (
+	errCreditExceedsInvoice = errors.New("credit exceeds the amount left on the invoice")
+	errCreditNoteNoTaken    = errors.New("credit note number was taken while rendering")
+)
+
+// creditNoteIssueAttempts bounds the retries when another credit note takes the number first.
+const creditNoteIssueAttempts = 3
+
+// issueCreditNote renders, numbers and stores a credit note against the order's current invoice.
+func issueCreditNote(
+	app *pocketbase.PocketBase,
+	e *core.RequestEvent,
+	original *core.Record,
+	req creditNoteRequest,
+	previewTemplatePath string,
+) (*core.Record, error) {
+	if original.GetString("kind") != "invoice" {
+		return nil, errors.New("credit notes can only be issued against an invoice")
+	}
+	if original.GetString("status") != "issued" {
+		return nil, errors.New("credit notes can only be issued against the current version of an invoice")
+	}
+
+	view, totals, err := buildCreditNoteView(original, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		rec, err := storeCreditNote(app, e, original, view, totals, previewTemplatePath)
+		if !errors.Is(err, errCreditNoteNoTaken) || attempt == creditNoteIssueAttempts {
+			return rec, err
+		}
+	}
+}
+
+// storeCreditNote renders the credit note under the next number outside the write transaction,
+// which is kept short: it only checks the number is still free and the credit still fits
+// before saving.
+func storeCreditNote(
+	app core.App,
+	e *core.RequestEvent,
+	original *core.Record,
+	view invoiceViewModel,
+	totals invoiceTotals,
+	previewTemplatePath string,
+) (*core.Record, error) {
+	sequence, err := nextCreditNoteSequence(app)
+	if err != nil {
+		return nil, err
+	}
+	view.InvoiceNo = formatCreditNoteNo(sequence)
+
+	html, err := renderInvoiceTemplate(previewTemplatePath, view)
+	if err != nil {
+		return nil, &invoiceStageError{Stage: "render_html", Err: err}
+	}
+	pdfBytes, err := renderInvoicePdf(html, view)
+	if err != nil {
+		return nil, &invoiceStageError{Stage: "render_pdf", Err: err}
+	}
+	pdfFile, err := filesystem.NewFileFromBytes(pdfBytes, fmt.Sprintf("credit-note-%s.pdf", view.InvoiceNo))
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := app.FindCollectionByNameOrId("invoices")
+	if err != nil {
+		return nil, err
+	}
+	rec := core.NewRecord(collection)
+
+	err = app.RunInTransaction(func(txApp core.App) error {
+		next, err := nextCreditNoteSequence(txApp)
+		if err != nil {
+			return err
+		}
+		if next != sequence {
+			return errCreditNoteNoTaken
+		}
+
+		already, err := creditedSoFar(txApp, original.GetString("orderId"))
+		if err != nil {
+			return err
+		}
+		if already+totals.GrandTotal > original.GetFloat("grandTotal")+totalsTolerance {
+			return errCreditExceedsInvoice
+		}
+
+		rec.Set("kind", "credit_note")
+		rec.Set("sequence", sequence)
+		rec.Set("creditedInvoiceId", original.Id)
+		rec.Set("reason", view.Reason)
+		rec.Set("orderId", original.GetString("orderId"))
+		rec.Set("customerId", original.GetString("customerId"))
+		if e != nil && e.Auth != nil && e.Auth.Collection().Name == "users" {
+			rec.Set("issuedBy", e.Auth.Id)
+		}
+		rec.Set("invoiceNo", view.InvoiceNo)
+		rec.Set("version", 1)
+		rec.Set("status", "issued")
+		rec.Set("issueDate", time.Now())
+		rec.Set("vatRate", totals.VatRate)
+		rec.Set("subTotal", totals.SubTotal)
+		rec.Set("vatTotal", totals.VatTotal)
+		rec.Set("grandTotal", totals.GrandTotal)
+		rec.Set("rows", view.Rows)
+		rec.Set("snapshot", view)
+		rec.Set("html", html)
+		rec.Set("pdf", pdfFile)
+
+		return txApp.Save(rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+const (
+	maxBulkRecipients   = 500
+	maxBulkHTMLPreviews = 20
+	defaultBulkInterval = 2 * time.Second
+)
+
+type bulkEmailRequest struct {
+	// same filters as the orders export
+	From          string `json:"from"`
+	To            string `json:"to"`
+	PaymentStatus string `json:"paymentStatus"`
+	OrderStatus   string `json:"orderStatus"`
+
+	TemplateKey string `json:"templateKey"`
+	EmailType   string `json:"emailType"`
+	EventNote   string `json:"eventNote"`
+	DryRun      bool   `json:"dryRun"`
+}
+
+type bulkEmailRecipient struct {
+	OrderId  string `json:"orderId"`
+	OrderNo  string `json:"orderNo"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Subject  string `json:"subject,omitempty"`
+	Text     string `json:"text,omitempty"`
+	HTML     string `json:"html,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Skipped  string `json:"skipped,omitempty"`
+	OutboxId string `json:"outboxId,omitempty"`
+}
+
+// resolveBulkEmailInterval is the gap between queued bulk messages (EMAIL_BULK_INTERVAL, eg "5s"),
+// so a large send trickles out instead of hitting the SMTP provider all at once.
+func resolveBulkEmailInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("EMAIL_BULK_INTERVAL"))
+	if raw == "" {
+		return defaultBulkInterval
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval < 0 {
+		fmt.Println("invalid EMAIL_BULK_INTERVAL, using default:", raw)
+		return defaultBulkInterval
+	}
+	return interval
+}
+
+// buildBulkEmailView gives bulk templates the whole order: invoice figures and the recommendation summary.
+func buildBulkEmailView(app core.App, src *orderInvoiceSource, payload invoicePayload) (emailViewModel, error) {
+	payments, err := loadInvoicePayments(app, src.Order.Id)
+	if err != nil {
+		return emailViewModel{}, err
+	}
+	// recomputed figures, so no invoice number: the issued one may carry different ones
+	invoice := buildInvoiceViewModel(payload, payments)
+
+	view := buildEmailViewModel(payload)
+	view.Invoice = &invoice
+	view.Recommendation = buildEmailRecommendation(payload)
+	return view, nil
+}
+
+func handleBulkEmail(app *pocketbase.PocketBase, e *core.RequestEvent, previewTemplatePath string) error {
+	var req bulkEmailRequest
+	if err := bindPayload(e, &req); err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":      false,
+			"error":   "Invalid payload.",
+			"details": err.Error(),
+		})
+	}
+
+	templateKey := normalizeEmailTemplateKey(req.TemplateKey)
+	if templateKey == "" {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":    false,
+			"error": "Missing templateKey.",
+		})
+	}
+
+	emailType := firstNonEmpty(strings.TrimSpace(req.EmailType), "generic")
+	if !isAllowedEmailType(emailType) {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":    false,
+			"error": fmt.Sprintf("Unknown emailType %q.", emailType),
+		})
+	}
+
+	filter, err := buildOrdersFilter(
+		"",
+		strings.TrimSpace(req.From),
+		strings.TrimSpace(req.To),
+		strings.TrimSpace(req.PaymentStatus),
+		strings.TrimSpace(req.OrderStatus),
+	)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":    false,
+			"error": err.Error(),
+		})
+	}
+
+	orders, err := app.FindRecordsByFilter("orders", filter, "created", maxBulkRecipients+1, 0)
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to load orders.",
+			"details": err.Error(),
+		})
+	}
+	if len(orders) > maxBulkRecipients {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":    false,
+			"error": fmt.Sprintf("Too many orders (more than %d). Please narrow the filters.", maxBulkRecipients),
+		})
+	}
+
+	viewsDir := filepath.Dir(previewTemplatePath)
+	bulkId := security.RandomString(12)
+	interval := resolveBulkEmailInterval()
+	sendAt := time.Now()
+
+	recipients := make([]bulkEmailRecipient, 0, len(orders))
+	queued, skipped, failed := 0, 0, 0
+
+	for _, order := range orders {
+		recipient := bulkEmailRecipient{
+			OrderId: order.Id,
+			OrderNo: fmt.Sprintf("%d", order.GetInt("orderNo")),
+		}
+
+		src, err := loadOrderInvoiceSource(app, order.Id)
+		if err != nil {
+			recipient.Error = err.Error()
+			failed++
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		payload := src.toInvoicePayload()
+		recipient.Email = strings.TrimSpace(payload.Customer.Email)
+		recipient.Name = buildCustomerDisplayName(payload)
+
+		if recipient.Email == "" {
+			recipient.Skipped = "no customer email"
+			skipped++
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		view, err := buildBulkEmailView(app, src, payload)
+		var rendered renderedEmail
+		if err == nil {
+			rendered, err = renderEmailTemplate(app, viewsDir, templateKey, "", view)
+		}
+		if err != nil {
+			recipient.Error = err.Error()
+			failed++
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		recipient.Subject = rendered.Subject
+		recipient.Text = rendered.Text
+
+		if req.DryRun {
+			if len(recipients) < maxBulkHTMLPreviews {
+				recipient.HTML = rendered.HTML
+			}
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		logCtx, meta := buildEmailLogContextFromPayload(payload, emailType, "bulk", templateKey)
+		logCtx.EventNote = strings.TrimSpace(req.EventNote)
+		meta["bulkId"] = bulkId
+
+		logRec, err := createEmailLog(app, e, recipient.Email, recipient.Name, rendered.Subject, logCtx, meta)
+		if err != nil {
+			// unlike one-off sends, a bulk message must be traceable, so no log means no send
+			recipient.Error = err.Error()
+			failed++
+			recipients = append(recipients, recipient)
+			continue
+		}
+		recordRenderedEmail(logRec, rendered)
+
+		msg := &mailer.Message{
+			From: mail.Address{
+				Address: app.Settings().Meta.SenderAddress,
+				Name:    app.Settings().Meta.SenderName,
+			},
+			To:      []mail.Address{{Address: recipient.Email, Name: recipient.Name}},
+			Subject: rendered.Subject,
+			HTML:    rendered.HTML,
+			Text:    rendered.Text,
+		}
+
+		outbox, err := queueEmailForLogAt(app, logRec, msg, map[string]any{"scheduledFor": sendAt.UTC().Format(time.RFC3339)}, sendAt)
+		if err != nil {
+			recipient.Error = err.Error()
+			failed++
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		recipient.OutboxId = outbox.Id
+		recipient.Text = ""
+		queued++
+		sendAt = sendAt.Add(interval)
+		recipients = append(recipients, recipient)
+	}
+
+	result := map[string]any{
+		"ok":          true,
+		"dryRun":      req.DryRun,
+		"templateKey": templateKey,
+		"total":       len(orders),
+		"skipped":     skipped,
+		"failed":      failed,
+		"recipients":  recipients,
+	}
+	if !req.DryRun {
+		result["bulkId"] = bulkId
+		result["queued"] = queued
+	}
+	return e.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// how far the signed timestamp may drift from our clock before an event is treated as a replay
+	emailEventTolerance = 5 * time.Minute
+	// per log, so a busy thread of opens doesn't grow meta forever
+	maxEmailDeliveryEvents = 20
+)
+
+var errInvalidEventSignature = errors.New("invalid signature")
+
+// emailDeliveryEvent is the generic event shape. "event" is accepted as an alias of "type",
+// and provider names (bounce, complaint, open, ...) are folded onto ours.
+type emailDeliveryEvent struct {
+	Type       string `json:"type"`
+	Event      string `json:"event"`
+	MessageId  string `json:"messageId"`
+	BounceType string `json:"bounceType"` // "hard" or "soft"
+	Reason     string `json:"reason"`
+	OccurredAt string `json:"occurredAt"` // RFC 3339, defaults to now
+}
+
+var emailDeliveryEventTypes = map[string]string{
+	"delivered":      "delivered",
+	"delivery":       "delivered",
+	"bounced":        "bounced",
+	"bounce":         "bounced",
+	"complained":     "complained",
+	"complaint":      "complained",
+	"spam_complaint": "complained",
+	"opened":         "opened",
+	"open":           "opened",
+}
+
+// assignMessageId gives msg a Message-ID we can look the log up by when the provider reports
+// on it, and returns it without the angle brackets.
+func assignMessageId(msg *mailer.Message) string {
+	for key, value := range msg.Headers {
+		if strings.EqualFold(key, "Message-ID") {
+			return trimMessageId(value)
+		}
+	}
+
+	domain := "localhost"
+	if _, d, ok := strings.Cut(msg.From.Address, "@"); ok && d != "" {
+		domain = d
+	}
+	id := fmt.Sprintf("%s@%s", security.RandomString(24), domain)
+
+	if msg.Headers == nil {
+		msg.Headers = map[string]string{}
+	}
+	msg.Headers["Message-ID"] = "<" + id + ">"
+	return id
+}
+
+// verifyEmailEventSignature checks X-Webhook-Signature: hex HMAC-SHA256 of "<timestamp>.<body>"
+// keyed with EMAIL_EVENTS_SECRET, where timestamp (unix seconds) is X-Webhook-Timestamp.
+func verifyEmailEventSignature(secret string, timestamp string, signature string, body []byte, now time.Time) error {
+	if secret == "" {
+		return errors.New("webhook secret not configured")
+	}
+
+	seconds, err := strconv.ParseInt(strings.TrimSpace(timestamp), 10, 64)
+	if err != nil {
+		return errInvalidEventSignature
+	}
+	if drift := now.Sub(time.Unix(seconds, 0)); drift > emailEventTolerance || drift < -emailEventTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", errInvalidEventSignature)
+	}
+
+	given, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))
+	if err != nil {
+		return errInvalidEventSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.TrimSpace(timestamp) + "."))
+	mac.Write(body)
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return errInvalidEventSignature
+	}
+	return nil
+}
+
+// parseEmailDeliveryEvents accepts a single event, an array, or {"events": [...]}.
+func parseEmailDeliveryEvents(body []byte) ([]emailDeliveryEvent, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, errors.New("empty body")
+	}
+
+	if trimmed[0] == '[' {
+		var events []emailDeliveryEvent
+		err := json.Unmarshal(trimmed, &events)
+		return events, err
+	}
+
+	var wrapped struct {
+		Events []emailDeliveryEvent `json:"events"`
+	}
+	if err := json.Unmarshal(trimmed, &wrapped); err != nil {
+		return nil, err
+	}
+	if wrapped.Events != nil {
+		return wrapped.Events, nil
+	}
+
+	var single emailDeliveryEvent
+	if err := json.Unmarshal(trimmed, &single); err != nil {
+		return nil, err
+	}
+	return []emailDeliveryEvent{single}, nil
+}
+
+func handleEmailEvents(app core.App, e *core.RequestEvent) error {
+	body, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":      false,
+			"error":   "Invalid payload.",
+			"details": err.Error(),
+		})
+	}
+
+	if err := verifyEmailEventSignature(
+		strings.TrimSpace(os.Getenv("EMAIL_EVENTS_SECRET")),
+		e.Request.Header.Get("X-Webhook-Timestamp"),
+		e.Request.Header.Get("X-Webhook-Signature"),
+		body,
+		time.Now(),
+	); err != nil {
+		return e.JSON(http.StatusUnauthorized, map[string]any{
+			"ok":      false,
+			"error":   "Invalid webhook signature.",
+			"details": err.Error(),
+		})
+	}
+
+	events, err := parseEmailDeliveryEvents(body)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":      false,
+			"error":   "Invalid payload.",
+			"details": err.Error(),
+		})
+	}
+
+	// unknown messages and event types are acknowledged so the provider doesn't keep retrying them
+	counts := map[string]int{}
+	for _, event := range events {
+		result, err := applyEmailDeliveryEvent(app, event)
+		if err != nil {
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to apply event.",
+				"details": err.Error(),
+			})
+		}
+		counts[result]++
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{
+		"ok":        true,
+		"updated":   counts["updated"],
+		"unmatched": counts["unmatched"],
+		"ignored":   counts["ignored"],
+		"duplicate": counts["duplicate"],
+	})
+}
+
+// applyEmailDeliveryEvent updates the email_logs row the event is about and returns
+// "updated", "unmatched", "ignored" or "duplicate". Bounced and complained are final: later
+// delivered or opened events don't move the status back. Providers retry webhooks, so an event
+// already in deliveryEvents (same type and time) is skipped.
+func applyEmailDeliveryEvent(app core.App, event emailDeliveryEvent) (string, error) {
+	kind := emailDeliveryEventTypes[strings.ToLower(strings.TrimSpace(firstNonEmpty(event.Type, event.Event)))]
+	messageId := trimMessageId(event.MessageId)
+	if kind == "" || messageId == "" {
+		return "ignored", nil
+	}
+
+	logRec, err := app.FindFirstRecordByData("email_logs", "messageId", messageId)
+	if err != nil {
+		return "unmatched", nil
+	}
+
+	occurredAt := time.Now()
+	if parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(event.OccurredAt)); err == nil {
+		occurredAt = parsed
+	}
+	at, _ := types.ParseDateTime(occurredAt)
+
+	history, _ := readEmailLogMeta(logRec)["deliveryEvents"].([]any)
+	for _, item := range history {
+		if seen, ok := item.(map[string]any); ok && seen["type"] == kind && seen["at"] == at.String() {
+			return "duplicate", nil
+		}
+	}
+
+	status := logRec.GetString("status")
+	final := status == "bounced" || status == "complained"
+	errMsg := logRec.GetString("error")
+	hardBounce := kind == "bounced" && !strings.EqualFold(strings.TrimSpace(event.BounceType), "soft")
+
+	nextStatus := ""
+	switch kind {
+	case "delivered", "opened":
+		if logRec.GetDateTime("deliveredAt").IsZero() {
+			logRec.Set("deliveredAt", at)
+		}
+		if kind == "opened" && logRec.GetDateTime("openedAt").IsZero() {
+			logRec.Set("openedAt", at)
+		}
+		if !final {
+			nextStatus = "delivered"
+		}
+	case "bounced":
+		// soft bounces are retried by the provider, so they are only recorded
+		if hardBounce {
+			nextStatus = "bounced"
+			errMsg = firstNonEmpty(strings.TrimSpace(event.Reason), "Hard bounce")
+		}
+	case "complained":
+		nextStatus = "complained"
+	}
+
+	entry := map[string]any{"type": kind, "at": at.String()}
+	if event.BounceType != "" {
+		entry["bounceType"] = strings.ToLower(strings.TrimSpace(event.BounceType))
+	}
+	if event.Reason != "" {
+		entry["reason"] = strings.TrimSpace(event.Reason)
+	}
+	history = append(history, entry)
+	if len(history) > maxEmailDeliveryEvents {
+		history = history[len(history)-maxEmailDeliveryEvents:]
+	}
+
+	if err := app.RunInTransaction(func(txApp core.App) error {
+		updateEmailLog(txApp, logRec, nextStatus, errMsg, map[string]any{"deliveryEvents": history})
+
+		if hardBounce {
+			return flagBouncedCustomer(txApp, logRec, errMsg, at)
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	return "updated", nil
+}
+
+// flagBouncedCustomer marks the customer the log was sent to, falling back to the order's
+// customer with the same address.
+func flagBouncedCustomer(app core.App, logRec *core.Record, reason string, at types.DateTime) error {
+	var customer *core.Record
+	if id := logRec.GetString("customerId"); id != "" {
+		customer, _ = app.FindRecordById("customers", id)
+	}
+	if customer == nil && logRec.GetString("orderId") != "" {
+		customers, err := app.FindRecordsByFilter(
+			"customers",
+			"orderId = {:orderId}",
+			"",
+			1,
+			0,
+			dbx.Params{"orderId": logRec.GetString("orderId")},
+		)
+		if err != nil {
+			return err
+		}
+		if len(customers) > 0 {
+			customer = customers[0]
+		}
+	}
+	if customer == nil {
+		return nil
+	}
+
+	// a newer address than the one that bounced is not flagged
+	if !strings.EqualFold(strings.TrimSpace(customer.GetString("email")), strings.TrimSpace(logRec.GetString("toEmail"))) {
+		return nil
+	}
+
+	customer.Set("emailBounced", true)
+	customer.Set("emailBouncedAt", at)
+	customer.Set("emailBounceReason", reason)
+	return app.Save(customer)
+}
+
+// registerEmailBounceHooks clears the bounce flag when staff correct the customer's address.
+func registerEmailBounceHooks(app *pocketbase.PocketBase) {
+	app.OnRecordUpdate("customers").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetBool("emailBounced") &&
+			!strings.EqualFold(e.Record.GetString("email"), e.Record.Original().GetString("email")) {
+			e.Record.Set("emailBounced", false)
+			e.Record.Set("emailBouncedAt", "")
+			e.Record.Set("emailBounceReason", "")
+		}
+		return e.Next()
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestApplyEmailDeliveryEventSkipsDuplicates(t *testing.T) {
+	app := newTestApp(t)
+
+	logRec, err := createEmailLog(app, nil, "jane@example.com", "Jane", "Your order", emailLogContext{EmailType: "status_update"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logRec.Set("messageId", "abc123@example.com")
+	if err := app.Save(logRec); err != nil {
+		t.Fatal(err)
+	}
+
+	event := emailDeliveryEvent{
+		Type:       "delivered",
+		MessageId:  "<abc123@example.com>",
+		OccurredAt: "2026-06-01T10:00:00Z",
+	}
+	opened := emailDeliveryEvent{
+		Type:       "opened",
+		MessageId:  "abc123@example.com",
+		OccurredAt: "2026-06-01T10:00:00Z",
+	}
+
+	scenarios := []struct {
+		event    emailDeliveryEvent
+		expected string
+	}{
+		{event, "updated"},
+		{event, "duplicate"},
+		// same time but another type is a new event
+		{opened, "updated"},
+		{opened, "duplicate"},
+	}
+	for i, s := range scenarios {
+		result, err := applyEmailDeliveryEvent(app, s.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != s.expected {
+			t.Errorf("event %d: expected %q, got %q", i, s.expected, result)
+		}
+	}
+
+	fresh, err := app.FindRecordById("email_logs", logRec.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, _ := readEmailLogMeta(fresh)["deliveryEvents"].([]any)
+	if len(history) != 2 {
+		t.Fatalf("expected 2 delivery events, got %d: %v", len(history), history)
+	}
+	if status := fresh.GetString("status"); status != "delivered" {
+		t.Errorf("expected status delivered, got %q", status)
+	}
+}
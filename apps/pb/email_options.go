@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+const (
+	maxEmailSubjectLength = 200
+	maxEmailMessageLength = 5000
+	maxEmailCopyAddresses = 10
+)
+
+// emailOptions are the per-send overrides staff can set from the email dialog.
+type emailOptions struct {
+	Subject string
+	Message string
+	Cc      []mail.Address
+	Bcc     []mail.Address
+	ReplyTo *mail.Address
+}
+
+// emailOptionsError names the emailContext field that failed validation.
+type emailOptionsError struct {
+	Field string
+	Err   error
+}
+
+func (e *emailOptionsError) Error() string { return fmt.Sprintf("%s: %s", e.Field, e.Err.Error()) }
+func (e *emailOptionsError) Unwrap() error { return e.Err }
+
+func parseEmailAddressList(field string, values []string) ([]mail.Address, error) {
+	if len(values) > maxEmailCopyAddresses {
+		return nil, &emailOptionsError{Field: field, Err: fmt.Errorf("at most %d addresses", maxEmailCopyAddresses)}
+	}
+
+	result := make([]mail.Address, 0, len(values))
+	seen := map[string]bool{}
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(strings.TrimSpace(value))
+		if err != nil {
+			return nil, &emailOptionsError{Field: field, Err: fmt.Errorf("invalid address %q", value)}
+		}
+		key := strings.ToLower(addr.Address)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, *addr)
+	}
+	return result, nil
+}
+
+// resolveEmailOptions validates the optional overrides on the email context.
+func resolveEmailOptions(ctx *emailContextPayload) (emailOptions, error) {
+	opts := emailOptions{}
+	if ctx == nil {
+		return opts, nil
+	}
+
+	opts.Subject = strings.Join(strings.Fields(ctx.Subject), " ")
+	if len(opts.Subject) > maxEmailSubjectLength {
+		return opts, &emailOptionsError{Field: "subject", Err: fmt.Errorf("must be at most %d characters", maxEmailSubjectLength)}
+	}
+
+	opts.Message = strings.TrimSpace(ctx.Message)
+	if len(opts.Message) > maxEmailMessageLength {
+		return opts, &emailOptionsError{Field: "message", Err: fmt.Errorf("must be at most %d characters", maxEmailMessageLength)}
+	}
+
+	var err error
+	if opts.Cc, err = parseEmailAddressList("cc", ctx.Cc); err != nil {
+		return opts, err
+	}
+	if opts.Bcc, err = parseEmailAddressList("bcc", ctx.Bcc); err != nil {
+		return opts, err
+	}
+
+	if replyTo := strings.TrimSpace(ctx.ReplyTo); replyTo != "" {
+		addr, err := mail.ParseAddress(replyTo)
+		if err != nil {
+			return opts, &emailOptionsError{Field: "replyTo", Err: fmt.Errorf("invalid address %q", replyTo)}
+		}
+		opts.ReplyTo = addr
+	}
+
+	return opts, nil
+}
+
+func respondEmailOptionsError(e *core.RequestEvent, err error) error {
+	result := map[string]any{
+		"ok":      false,
+		"error":   "Invalid email options.",
+		"details": err.Error(),
+	}
+	var optsErr *emailOptionsError
+	if errors.As(err, &optsErr) {
+		result["field"] = optsErr.Field
+	}
+	return e.JSON(http.StatusBadRequest, result)
+}
+
+// apply puts the overrides on the outgoing message. The staff message goes in through the
+// view model (emailViewModel.Message), since templates decide where it sits.
+func (o emailOptions) apply(msg *mailer.Message) {
+	if o.Subject != "" {
+		msg.Subject = o.Subject
+	}
+	if len(o.Cc) > 0 {
+		msg.Cc = o.Cc
+	}
+	if len(o.Bcc) > 0 {
+		msg.Bcc = o.Bcc
+	}
+	if o.ReplyTo != nil {
+		if msg.Headers == nil {
+			msg.Headers = map[string]string{}
+		}
+		msg.Headers["Reply-To"] = o.ReplyTo.String()
+	}
+}
+
+func addressStrings(addrs []mail.Address) []string {
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, addr.String())
+	}
+	return result
+}
+
+// meta is what gets recorded on the email log so a send can be reconstructed later.
+func (o emailOptions) meta() map[string]any {
+	meta := map[string]any{}
+	if o.Subject != "" {
+		meta["subjectOverride"] = o.Subject
+	}
+	if o.Message != "" {
+		meta["staffMessage"] = o.Message
+	}
+	if len(o.Cc) > 0 {
+		meta["cc"] = addressStrings(o.Cc)
+	}
+	if len(o.Bcc) > 0 {
+		meta["bcc"] = addressStrings(o.Bcc)
+	}
+	if o.ReplyTo != nil {
+		meta["replyTo"] = o.ReplyTo.String()
+	}
+	return meta
+}
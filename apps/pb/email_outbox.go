@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/mail"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	outboxPollInterval = 15 * time.Second
+	outboxBatchSize    = 10
+	outboxMaxAttempts  = 8
+	outboxBaseBackoff  = 30 * time.Second
+	outboxMaxBackoff   = 2 * time.Hour
+)
+
+// emailOutboxWorker delivers email_outbox rows in the background. Rows are the source of
+// truth, so anything queued before a restart is picked up again on the next start.
+type emailOutboxWorker struct {
+	app    core.App
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var (
+	emailOutboxMu     sync.Mutex
+	activeEmailOutbox *emailOutboxWorker
+)
+
+// startEmailOutboxWorker runs the delivery loop until the app terminates.
+func startEmailOutboxWorker(app core.App) {
+	emailOutboxMu.Lock()
+	defer emailOutboxMu.Unlock()
+
+	if activeEmailOutbox != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &emailOutboxWorker{
+		app:    app,
+		wake:   make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	activeEmailOutbox = w
+
+	// a crash mid-send leaves rows in "sending"; nothing else can own them at startup
+	if err := w.requeueInterrupted(); err != nil {
+		fmt.Println("email outbox requeue failed:", err.Error())
+	}
+
+	go w.run(ctx)
+}
+
+// stopEmailOutboxWorker stops the loop and waits for the in-flight batch to finish.
+func stopEmailOutboxWorker() {
+	emailOutboxMu.Lock()
+	w := activeEmailOutbox
+	activeEmailOutbox = nil
+	emailOutboxMu.Unlock()
+
+	if w == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+// wakeEmailOutbox nudges the worker so freshly queued mail doesn't wait for the next poll.
+func wakeEmailOutbox() {
+	emailOutboxMu.Lock()
+	w := activeEmailOutbox
+	emailOutboxMu.Unlock()
+
+	if w == nil {
+		return
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *emailOutboxWorker) run(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.processDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+func (w *emailOutboxWorker) requeueInterrupted() error {
+	stuck, err := w.app.FindRecordsByFilter("email_outbox", `status = "sending"`, "", 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, rec := range stuck {
+		rec.Set("status", "queued")
+		rec.Set("nextAttemptAt", types.NowDateTime())
+		if err := w.app.Save(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processDue sends everything that is due, a batch at a time, until the queue is drained.
+func (w *emailOutboxWorker) processDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := w.app.FindRecordsByFilter(
+			"email_outbox",
+			`status = "queued" && nextAttemptAt <= {:now}`,
+			"nextAttemptAt",
+			outboxBatchSize,
+			0,
+			dbx.Params{"now": types.NowDateTime().String()},
+		)
+		if err != nil {
+			fmt.Println("email outbox poll failed:", err.Error())
+			return
+		}
+		if len(due) == 0 {
+			return
+		}
+
+		for _, rec := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			// an unclaimed row is still due, so polling again now would fetch the same batch in
+			// a busy loop; it is retried on the next tick instead
+			rec.Set("status", "sending")
+			if err := w.app.Save(rec); err != nil {
+				fmt.Println("email outbox claim failed:", err.Error())
+				return
+			}
+			w.deliver(rec)
+		}
+	}
+}
+
+// deliver sends a claimed ("sending") row and records the outcome on it and its email log.
+func (w *emailOutboxWorker) deliver(rec *core.Record) {
+	logRec := findOutboxEmailLog(w.app, rec)
+	updateEmailLog(w.app, logRec, "sending", "", nil)
+
+	attempts := rec.GetInt("attempts") + 1
+	rec.Set("attempts", attempts)
+
+	sendStart := time.Now()
+	msg, err := buildOutboxMessage(w.app, rec)
+	if err == nil {
+		err = currentMailClient(w.app).Send(msg)
+	}
+	sendMs := time.Since(sendStart).Milliseconds()
+
+	if err == nil {
+		rec.Set("status", "sent")
+		rec.Set("lastError", "")
+		if saveErr := w.app.Save(rec); saveErr != nil {
+			fmt.Println("email outbox update failed:", saveErr.Error())
+		}
+		if logRec != nil {
+			logRec.Set("sentAt", types.NowDateTime())
+		}
+		updateEmailLog(w.app, logRec, "sent", "", map[string]any{
+			"stage":    "sent",
+			"sendMs":   sendMs,
+			"attempts": attempts,
+		})
+		return
+	}
+
+	rec.Set("lastError", err.Error())
+
+	if attempts >= outboxMaxAttempts {
+		rec.Set("status", "failed")
+		if saveErr := w.app.Save(rec); saveErr != nil {
+			fmt.Println("email outbox update failed:", saveErr.Error())
+		}
+		updateEmailLog(w.app, logRec, "failed", err.Error(), map[string]any{
+			"stage":    "send_email",
+			"sendMs":   sendMs,
+			"attempts": attempts,
+		})
+		return
+	}
+
+	next := time.Now().Add(outboxBackoff(attempts))
+	nextAttemptAt, _ := types.ParseDateTime(next)
+
+	rec.Set("status", "queued")
+	rec.Set("nextAttemptAt", nextAttemptAt)
+	if saveErr := w.app.Save(rec); saveErr != nil {
+		fmt.Println("email outbox update failed:", saveErr.Error())
+	}
+	updateEmailLog(w.app, logRec, "queued", err.Error(), map[string]any{
+		"stage":         "send_retry",
+		"sendMs":        sendMs,
+		"attempts":      attempts,
+		"nextAttemptAt": nextAttemptAt.String(),
+	})
+}
+
+// outboxBackoff doubles the wait after each failed attempt: 30s, 1m, 2m, ... capped.
+func outboxBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	wait := float64(outboxBaseBackoff) * math.Pow(2, float64(attempts-1))
+	if wait > float64(outboxMaxBackoff) {
+		return outboxMaxBackoff
+	}
+	return time.Duration(wait)
+}
+
+func findOutboxEmailLog(app core.App, rec *core.Record) *core.Record {
+	logId := rec.GetString("emailLogId")
+	if logId == "" {
+		return nil
+	}
+	logRec, err := app.FindRecordById("email_logs", logId)
+	if err != nil {
+		return nil
+	}
+	return logRec
+}
+
+// enqueueEmail persists a fully rendered message (attachments included) for the worker to send.
+func enqueueEmail(app core.App, logRec *core.Record, msg *mailer.Message) (*core.Record, error) {
+	return enqueueEmailAt(app, logRec, msg, time.Now())
+}
+
+// enqueueEmailAt is enqueueEmail with the first attempt held back until sendAt.
+func enqueueEmailAt(app core.App, logRec *core.Record, msg *mailer.Message, sendAt time.Time) (*core.Record, error) {
+	coll, err := app.FindCollectionByNameOrId("email_outbox")
+	if err != nil {
+		return nil, err
+	}
+
+	rec := core.NewRecord(coll)
+	if logRec != nil {
+		rec.Set("emailLogId", logRec.Id)
+	}
+	rec.Set("status", "queued")
+	rec.Set("fromAddress", msg.From.Address)
+	rec.Set("fromName", msg.From.Name)
+	rec.Set("to", msg.To)
+	rec.Set("cc", msg.Cc)
+	rec.Set("bcc", msg.Bcc)
+	rec.Set("headers", msg.Headers)
+	rec.Set("subject", msg.Subject)
+	rec.Set("html", msg.HTML)
+	rec.Set("text", msg.Text)
+	rec.Set("attempts", 0)
+	nextAttemptAt, err := types.ParseDateTime(sendAt)
+	if err != nil {
+		return nil, err
+	}
+	rec.Set("nextAttemptAt", nextAttemptAt)
+
+	// stored names get a random suffix, so keep the original filename alongside
+	names := make([]string, 0, len(msg.Attachments))
+	for name := range msg.Attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make([]*filesystem.File, 0, len(names))
+	originalNames := make(map[string]string, len(names))
+	for _, name := range names {
+		data, err := io.ReadAll(msg.Attachments[name])
+		if err != nil {
+			return nil, err
+		}
+		file, err := filesystem.NewFileFromBytes(data, name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		originalNames[file.Name] = name
+	}
+	if len(files) > 0 {
+		rec.Set("attachments", files)
+	}
+	rec.Set("attachmentNames", originalNames)
+
+	if err := app.Save(rec); err != nil {
+		return nil, err
+	}
+
+	wakeEmailOutbox()
+	return rec, nil
+}
+
+// buildOutboxMessage turns a stored outbox row back into a mailer message.
+func buildOutboxMessage(app core.App, rec *core.Record) (*mailer.Message, error) {
+	msg := &mailer.Message{
+		From: mail.Address{
+			Address: rec.GetString("fromAddress"),
+			Name:    rec.GetString("fromName"),
+		},
+		Subject: rec.GetString("subject"),
+		HTML:    rec.GetString("html"),
+		Text:    rec.GetString("text"),
+	}
+
+	if err := rec.UnmarshalJSONField("to", &msg.To); err != nil {
+		return nil, fmt.Errorf("outbox recipients unreadable: %w", err)
+	}
+	_ = rec.UnmarshalJSONField("cc", &msg.Cc)
+	_ = rec.UnmarshalJSONField("bcc", &msg.Bcc)
+	_ = rec.UnmarshalJSONField("headers", &msg.Headers)
+
+	stored := rec.GetStringSlice("attachments")
+	if len(stored) == 0 {
+		return msg, nil
+	}
+
+	originalNames := map[string]string{}
+	_ = rec.UnmarshalJSONField("attachmentNames", &originalNames)
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	msg.Attachments = make(map[string]io.Reader, len(stored))
+	for _, filename := range stored {
+		reader, err := fsys.GetReader(rec.BaseFilesPath() + "/" + filename)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		msg.Attachments[firstNonEmpty(originalNames[filename], filename)] = bytes.NewReader(data)
+	}
+
+	return msg, nil
+}
+
+// queueEmailForLog enqueues msg and marks the log as queued. The caller reports err to the client.
+func queueEmailForLog(app core.App, logRec *core.Record, msg *mailer.Message, metaPatch map[string]any) (*core.Record, error) {
+	return queueEmailForLogAt(app, logRec, msg, metaPatch, time.Now())
+}
+
+func queueEmailForLogAt(app core.App, logRec *core.Record, msg *mailer.Message, metaPatch map[string]any, sendAt time.Time) (*core.Record, error) {
+	if logRec != nil {
+		tagReplyTo(msg, logRec.GetString("orderId"))
+		logRec.Set("messageId", assignMessageId(msg))
+	}
+
+	rec, err := enqueueEmailAt(app, logRec, msg, sendAt)
+	if err != nil {
+		updateEmailLog(app, logRec, "failed", err.Error(), mergeMeta(metaPatch, map[string]any{
+			"stage": "enqueue",
+		}))
+		return nil, err
+	}
+
+	updateEmailLog(app, logRec, "queued", "", mergeMeta(metaPatch, map[string]any{
+		"stage":    "queued",
+		"outboxId": rec.Id,
+	}))
+	return rec, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+func TestEmailOutboxStopsOnFailedClaim(t *testing.T) {
+	app := newTestApp(t)
+
+	for _, to := range []string{"jane@example.com", "john@example.com"} {
+		msg := &mailer.Message{
+			From:    mail.Address{Address: "studio@example.com"},
+			To:      []mail.Address{{Address: to}},
+			Subject: "Your order",
+			HTML:    "<p>Hello</p>",
+		}
+		if _, err := enqueueEmailAt(app, nil, msg, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claims := 0
+	app.OnRecordUpdate("email_outbox").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("status") == "sending" {
+			claims++
+			return errors.New("database is locked")
+		}
+		return e.Next()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		(&emailOutboxWorker{app: app}).processDue(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected processDue to give up after a failed claim")
+	}
+
+	if claims != 1 {
+		t.Errorf("expected one claim attempt before waiting for the next poll, got %d", claims)
+	}
+	if queued, _ := app.CountRecords("email_outbox", dbx.HashExp{"status": "queued"}); queued != 2 {
+		t.Errorf("expected both emails still queued, got %d", queued)
+	}
+	if sent := len(testMailClient(t, app).Messages()); sent != 0 {
+		t.Errorf("expected nothing sent, got %d", sent)
+	}
+}
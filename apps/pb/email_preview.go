@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type emailPreviewAttachment struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// defaultPreviewTemplateKey mirrors the routes: recommendation types use the recommendation
+// template, everything else previews as an invoice email.
+func defaultPreviewTemplateKey(payload invoicePayload) string {
+	if payload.EmailContext != nil {
+		if key := normalizeEmailTemplateKey(payload.EmailContext.TemplateKey); key != "" {
+			return key
+		}
+		if strings.HasPrefix(strings.TrimSpace(payload.EmailContext.EmailType), "recommendation") {
+			return "email.recommendation"
+		}
+	}
+	return "email.invoice"
+}
+
+// handleEmailPreview renders exactly what /api/email/invoice or /api/email/recommendation would
+// queue, without sending, storing an invoice or writing an email_logs row.
+func handleEmailPreview(app *pocketbase.PocketBase, e *core.RequestEvent, previewTemplatePath string) error {
+	var payload invoicePayload
+	if err := bindPayload(e, &payload); err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":      false,
+			"error":   "Invalid payload.",
+			"details": err.Error(),
+		})
+	}
+
+	opts, err := resolveEmailOptions(payload.EmailContext)
+	if err != nil {
+		return respondEmailOptionsError(e, err)
+	}
+
+	templateKey := defaultPreviewTemplateKey(payload)
+
+	view := buildEmailViewModel(payload)
+	view.Message = opts.Message
+	attachments := []emailPreviewAttachment{}
+	result := map[string]any{"ok": true}
+
+	switch templateKey {
+	case "email.recommendation":
+		images, err := loadFrameReferenceImages(app, payload.Frames)
+		if err != nil {
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to load reference images.",
+				"details": err.Error(),
+			})
+		}
+		view.Recommendation = buildEmailRecommendation(payload)
+		view.Recommendation.Photos = len(images)
+		for _, image := range images {
+			attachments = append(attachments, emailPreviewAttachment{Name: image.Name, Size: len(image.Data)})
+		}
+	default:
+		// same guard as /api/email/invoice, so a preview never shows what the send would refuse
+		if mismatches := compareClientTotals(payload, computeInvoiceTotals(payload)); len(mismatches) > 0 {
+			return e.JSON(http.StatusConflict, map[string]any{
+				"ok":         false,
+				"error":      "Invoice totals are out of date. Please refresh the order and try again.",
+				"mismatches": mismatches,
+			})
+		}
+
+		var doc *invoiceDocument
+		var err error
+		if !payload.IssueNewVersion {
+			doc, err = findReusableInvoiceDocument(app, invoicePayloadOrderId(payload))
+		}
+		if err == nil && doc == nil {
+			// the number isn't reserved until the invoice is issued, so this is a best guess
+			invoiceNo := "-"
+			if invoicePayloadOrderId(payload) != "" {
+				invoiceNo = peekInvoiceNo(app, time.Now())
+			}
+			doc, err = renderInvoiceDocument(app, payload, previewTemplatePath, invoiceNo)
+		}
+		if err != nil {
+			stage := "render_html"
+			var stageErr *invoiceStageError
+			if errors.As(err, &stageErr) {
+				stage = stageErr.Stage
+			}
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to render invoice.",
+				"stage":   stage,
+				"details": err.Error(),
+			})
+		}
+
+		view.Invoice = &doc.Invoice
+		view.Recommendation = buildEmailRecommendation(payload)
+		result["invoiceReused"] = doc.Reused
+
+		if templateKey == "email.invoice" {
+			attachments = append(attachments, emailPreviewAttachment{Name: "invoice.pdf", Size: len(doc.PDF)})
+		}
+	}
+
+	rendered, err := renderEmailTemplate(app, filepath.Dir(previewTemplatePath), templateKey, "", view)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":          false,
+			"error":       "Failed to render email.",
+			"templateKey": templateKey,
+			"details":     err.Error(),
+		})
+	}
+
+	result["templateKey"] = rendered.TemplateKey
+	result["templateSource"] = rendered.Source
+	result["to"] = strings.TrimSpace(payload.Customer.Email)
+	result["subject"] = firstNonEmpty(opts.Subject, rendered.Subject)
+	result["cc"] = addressStrings(opts.Cc)
+	result["bcc"] = addressStrings(opts.Bcc)
+	if opts.ReplyTo != nil {
+		result["replyTo"] = opts.ReplyTo.String()
+	}
+	result["html"] = rendered.HTML
+	result["text"] = rendered.Text
+	result["attachments"] = attachments
+
+	return e.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// email bodies live next to the invoice template as email.<key>.html (or in email_templates,
+// see email_templates.go). Each file defines
+// "title" (the subject), "body" (wrapped by email.layout.html) and optionally "text",
+// the plain-text alternative. Without "text" the mailer derives one from the HTML.
+const emailLayoutTemplate = "email.layout.html"
+
+var emailTemplateKeyPattern = regexp.MustCompile(`^email\.[a-z0-9_-]+$`)
+
+type emailBrand struct {
+	Name    string
+	Phone   string
+	Website string
+}
+
+type emailLinks struct {
+	OrderForm   string
+	FrameStyles string
+	Terms       string
+}
+
+type emailCustomer struct {
+	Title       string
+	FirstName   string
+	Surname     string
+	DisplayName string
+}
+
+// Greeting is what the templates open with: first name, or "there" when we don't have one.
+func (c emailCustomer) Greeting() string {
+	return firstNonEmpty(strings.TrimSpace(c.FirstName), "there")
+}
+
+type emailOrder struct {
+	Ref          string
+	OrderNo      string
+	OccasionDate string
+}
+
+type emailRecommendation struct {
+	FrameName  string
+	FramePrice string
+	// optional upsell prices; the paragraphs are skipped when empty
+	SageMountPrice         string
+	SideProfileUpsizePrice string
+	Rows                   []invoiceRow
+	PortalTotal            string
+
+	// one entry per frame item, see recommendation_email.go
+	Frames []emailFrameRecommendation
+	// number of reference images attached
+	Photos int
+}
+
+// emailViewModel is the single typed model every email template renders against.
+type emailViewModel struct {
+	Brand    emailBrand
+	Links    emailLinks
+	Customer emailCustomer
+	Order    emailOrder
+
+	// set for invoice / credit note emails
+	Invoice *invoiceViewModel
+	// set for recommendation emails
+	Recommendation *emailRecommendation
+	// set for status update emails
+	Status *emailStatusUpdate
+	// set for payment reminders
+	Reminder *emailPaymentReminder
+
+	// optional note from staff, see email_options.go
+	Message string
+}
+
+// MessageHTML keeps the staff message's line breaks in the HTML body.
+func (v emailViewModel) MessageHTML() template.HTML {
+	escaped := template.HTMLEscapeString(strings.TrimSpace(v.Message))
+	return template.HTML(strings.ReplaceAll(escaped, "\n", "<br />\n"))
+}
+
+type renderedEmail struct {
+	TemplateKey string
+	Source      string // "file" or "db:v<version>"
+	Subject     string
+	HTML        string
+	Text        string
+}
+
+func resolveEmailBrand() emailBrand {
+	return emailBrand{
+		Name:    firstNonEmpty(strings.TrimSpace(os.Getenv("EMAIL_BRAND_NAME")), "Precious Petals"),
+		Phone:   firstNonEmpty(strings.TrimSpace(os.Getenv("EMAIL_BRAND_PHONE")), "01256 882422"),
+		Website: strings.TrimSpace(os.Getenv("EMAIL_LINK_WEBSITE")),
+	}
+}
+
+func resolveEmailLinks() emailLinks {
+	return emailLinks{
+		OrderForm:   strings.TrimSpace(os.Getenv("EMAIL_LINK_ORDER_FORM")),
+		FrameStyles: strings.TrimSpace(os.Getenv("EMAIL_LINK_FRAME_STYLES")),
+		Terms:       strings.TrimSpace(os.Getenv("EMAIL_LINK_TERMS")),
+	}
+}
+
+// buildEmailViewModel fills the parts every email shares from the invoice payload.
+func buildEmailViewModel(payload invoicePayload) emailViewModel {
+	orderNo := formatOrderNo(payload.Order.OrderNo.Float64())
+	ref := strings.TrimSpace(payload.Order.OrderRef)
+	if ref == "" && orderNo != "-" {
+		ref = "#" + orderNo
+	}
+
+	return emailViewModel{
+		Brand: resolveEmailBrand(),
+		Links: resolveEmailLinks(),
+		Customer: emailCustomer{
+			Title:       strings.TrimSpace(payload.Customer.Title),
+			FirstName:   strings.TrimSpace(payload.Customer.FirstName),
+			Surname:     strings.TrimSpace(payload.Customer.Surname),
+			DisplayName: buildCustomerDisplayName(payload),
+		},
+		Order: emailOrder{
+			Ref:          ref,
+			OrderNo:      orderNo,
+			OccasionDate: formatDate(string(payload.Order.OccasionDate)),
+		},
+	}
+}
+
+// buildEmailRecommendation summarises the order as it stands for the recommendation email.
+func buildEmailRecommendation(payload invoicePayload) *emailRecommendation {
+	rec := &emailRecommendation{
+		Rows:        buildInvoiceRows(payload),
+		PortalTotal: formatMoney(computeInvoiceTotals(payload).GrandTotal),
+	}
+
+	for i, frame := range payload.Frames {
+		rec.Frames = append(rec.Frames, buildEmailFrameRecommendation(i, frame))
+	}
+
+	if len(rec.Frames) > 0 {
+		first := rec.Frames[0]
+		name := first.FrameType
+		if name != "" {
+			name += " frame"
+		}
+		if size := firstNonEmpty(first.RecommendedSize, strings.TrimSpace(payload.Frames[0].Size)); size != "" {
+			name = strings.TrimSpace(fmt.Sprintf("%s (%s)", name, size))
+		}
+		rec.FrameName = name
+		rec.FramePrice = first.Total
+	}
+
+	return rec
+}
+
+// normalizeEmailTemplateKey accepts both "invoice" and "email.invoice".
+func normalizeEmailTemplateKey(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key == "" {
+		return ""
+	}
+	if !strings.HasPrefix(key, "email.") {
+		key = "email." + key
+	}
+	return strings.TrimSuffix(key, ".html")
+}
+
+// emailTemplateContent is what a key renders from: an email.<key>.html file with its define
+// blocks, or the parts of an email_templates row by template name ("title", "body", "text").
+type emailTemplateContent struct {
+	File  string
+	Parts map[string]string
+}
+
+// loadEmailTemplateSource returns the layout and the template content for a key, preferring an
+// active email_templates row and falling back to email.<key>.html on disk.
+func loadEmailTemplateSource(app core.App, viewsDir string, key string) (string, emailTemplateContent, string, error) {
+	if !emailTemplateKeyPattern.MatchString(key) || key == strings.TrimSuffix(emailLayoutTemplate, ".html") {
+		return "", emailTemplateContent{}, "", fmt.Errorf("invalid email template key %q", key)
+	}
+
+	layout, err := loadEmailLayout(viewsDir)
+	if err != nil {
+		return "", emailTemplateContent{}, "", err
+	}
+
+	if stored, err := findStoredEmailTemplate(app, key); err != nil {
+		fmt.Println("email template lookup failed:", err.Error())
+	} else if stored != nil {
+		content := storedEmailTemplateContent(stored.GetString("subject"), stored.GetString("html"), stored.GetString("text"))
+		return layout, content, fmt.Sprintf("db:v%d", stored.GetInt("version")), nil
+	}
+
+	file, err := os.ReadFile(filepath.Join(viewsDir, key+".html"))
+	if err != nil {
+		return "", emailTemplateContent{}, "", fmt.Errorf("email template %q not found: %w", key, err)
+	}
+
+	return layout, emailTemplateContent{File: string(file)}, "file", nil
+}
+
+// renderEmailTemplate executes the template picked by templateKey (falling back to
+// defaultKey) and returns subject, HTML and plain-text bodies.
+func renderEmailTemplate(app core.App, viewsDir string, templateKey string, defaultKey string, view emailViewModel) (renderedEmail, error) {
+	key := normalizeEmailTemplateKey(firstNonEmpty(templateKey, defaultKey))
+
+	layout, content, source, err := loadEmailTemplateSource(app, viewsDir, key)
+	if err != nil {
+		return renderedEmail{TemplateKey: key}, err
+	}
+
+	rendered, err := executeEmailTemplate(key, layout, content, view)
+	rendered.Source = source
+	return rendered, err
+}
+
+func executeEmailTemplate(key string, layout string, content emailTemplateContent, view emailViewModel) (renderedEmail, error) {
+	result := renderedEmail{TemplateKey: key}
+
+	var trees map[string]*parse.Tree
+	if content.Parts != nil {
+		var err error
+		if trees, err = parseEmailTemplateParts(content.Parts); err != nil {
+			return result, fmt.Errorf("parse %s failed: %w", key, err)
+		}
+	}
+
+	htmlTmpl, err := template.New(emailLayoutTemplate).Parse(layout)
+	if err != nil {
+		return result, fmt.Errorf("parse %s failed: %w", emailLayoutTemplate, err)
+	}
+	if trees != nil {
+		for name, tree := range trees {
+			if _, err := htmlTmpl.AddParseTree(name, tree.Copy()); err != nil {
+				return result, fmt.Errorf("parse %s failed: %w", key, err)
+			}
+		}
+	} else if _, err := htmlTmpl.New(key).Parse(content.File); err != nil {
+		return result, fmt.Errorf("parse %s failed: %w", key, err)
+	}
+
+	var htmlBuf bytes.Buffer
+	if err := htmlTmpl.ExecuteTemplate(&htmlBuf, emailLayoutTemplate, view); err != nil {
+		return result, fmt.Errorf("execute %s failed: %w", key, err)
+	}
+	result.HTML = htmlBuf.String()
+
+	// subject and text alternative are plain text, so they must not be HTML-escaped
+	textTmpl := texttemplate.New(key)
+	if trees != nil {
+		for name, tree := range trees {
+			if _, err := textTmpl.AddParseTree(name, tree.Copy()); err != nil {
+				return result, fmt.Errorf("parse %s failed: %w", key, err)
+			}
+		}
+	} else if _, err := textTmpl.Parse(content.File); err != nil {
+		return result, fmt.Errorf("parse %s failed: %w", key, err)
+	}
+
+	if textTmpl.Lookup("title") != nil {
+		var subject bytes.Buffer
+		if err := textTmpl.ExecuteTemplate(&subject, "title", view); err != nil {
+			return result, fmt.Errorf("execute %s title failed: %w", key, err)
+		}
+		result.Subject = strings.Join(strings.Fields(subject.String()), " ")
+	}
+
+	if textTmpl.Lookup("text") != nil {
+		var text bytes.Buffer
+		if err := textTmpl.ExecuteTemplate(&text, "text", view); err != nil {
+			return result, fmt.Errorf("execute %s text failed: %w", key, err)
+		}
+		result.Text = tidyEmailText(text.String())
+	}
+
+	return result, nil
+}
+
+var emailBlankLines = regexp.MustCompile(`\n{3,}`)
+
+// tidyEmailText drops trailing spaces and collapses the blank lines left by template actions.
+func tidyEmailText(value string) string {
+	lines := strings.Split(value, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(emailBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")) + "\n"
+}
+
+// recordRenderedEmail points the email log at the template and subject that were actually used.
+// The caller saves the log afterwards (updateEmailLog).
+func recordRenderedEmail(logRec *core.Record, rendered renderedEmail) {
+	if logRec == nil {
+		return
+	}
+	logRec.Set("templateKey", rendered.TemplateKey)
+	meta, _ := logRec.Get("meta").(map[string]any)
+	logRec.Set("meta", mergeMeta(meta, map[string]any{"templateSource": rendered.Source}))
+	if rendered.Subject != "" {
+		logRec.Set("subject", rendered.Subject)
+	}
+}
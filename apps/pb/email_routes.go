@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/mail"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -26,6 +27,59 @@ func registerEmailRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, previe
 			})
 		}
 
+		return sendInvoiceEmail(app, e, payload, previewTemplatePath)
+	}).Bind(apis.RequireAuth())
+
+	// same as /api/email/invoice, but built from the stored order so the email matches the DB
+	se.Router.POST("/api/orders/{id}/invoice/email", func(e *core.RequestEvent) error {
+		src, err := loadOrderInvoiceSource(app, e.Request.PathValue("id"))
+		if err != nil {
+			return orderInvoiceSourceError(e, err)
+		}
+
+		payload := src.toInvoicePayload()
+
+		// body is optional; it only carries emailContext overrides and the re-issue flag
+		var body struct {
+			EmailContext    *emailContextPayload `json:"emailContext"`
+			IssueNewVersion bool                 `json:"issueNewVersion"`
+		}
+		if err := bindPayload(e, &body); err == nil {
+			payload.EmailContext = body.EmailContext
+			payload.IssueNewVersion = body.IssueNewVersion
+		}
+
+		return sendInvoiceEmail(app, e, payload, previewTemplatePath)
+	}).Bind(apis.RequireAuth())
+
+	se.Router.POST("/api/email/credit-note", func(e *core.RequestEvent) error {
+		var body struct {
+			CreditNoteId string               `json:"creditNoteId"`
+			EmailContext *emailContextPayload `json:"emailContext"`
+		}
+		if err := bindPayload(e, &body); err != nil {
+			return e.JSON(http.StatusBadRequest, map[string]any{
+				"ok":      false,
+				"error":   "Invalid payload.",
+				"details": err.Error(),
+			})
+		}
+
+		note, err := app.FindRecordById("invoices", strings.TrimSpace(body.CreditNoteId))
+		if err != nil || note.GetString("kind") != "credit_note" {
+			return e.JSON(http.StatusNotFound, map[string]any{
+				"ok":    false,
+				"error": "Credit note not found.",
+			})
+		}
+
+		src, err := loadOrderInvoiceSource(app, note.GetString("orderId"))
+		if err != nil {
+			return orderInvoiceSourceError(e, err)
+		}
+		payload := src.toInvoicePayload()
+		payload.EmailContext = body.EmailContext
+
 		if strings.TrimSpace(payload.Customer.Email) == "" {
 			return e.JSON(http.StatusBadRequest, map[string]any{
 				"ok":    false,
@@ -33,13 +87,17 @@ func registerEmailRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, previe
 			})
 		}
 
-		subject := strings.TrimSpace(fmt.Sprintf("Invoice #%s", formatInvoiceNo(payload.Order.OrderNo.Float64())))
-		if subject == "Invoice #-" {
-			subject = "Invoice"
+		opts, err := resolveEmailOptions(payload.EmailContext)
+		if err != nil {
+			return respondEmailOptionsError(e, err)
 		}
 
-		// create log entry (attempted) - best effort
-		logCtx, meta := buildEmailLogContextFromPayload(payload, "invoice", "manual", "invoice")
+		subject := fmt.Sprintf("Credit note %s", note.GetString("invoiceNo"))
+
+		logCtx, meta := buildEmailLogContextFromPayload(payload, "credit_note", "manual", "email.credit_note")
+		meta = mergeMeta(meta, opts.meta())
+		subject = firstNonEmpty(opts.Subject, subject)
+		meta["invoiceId"] = note.Id
 		toName := buildCustomerDisplayName(payload)
 
 		var logRec *core.Record
@@ -49,36 +107,38 @@ func registerEmailRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, previe
 			fmt.Println("email log create failed:", err.Error())
 		}
 
-		// render invoice html
-		view := buildInvoiceViewModel(payload)
-		html, err := renderInvoiceTemplate(previewTemplatePath, view)
+		pdfBytes, err := readInvoicePdf(app, note)
 		if err != nil {
 			updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
-				"stage": "render_html",
+				"stage": "load_invoice",
 			})
 			return e.JSON(http.StatusInternalServerError, map[string]any{
 				"ok":      false,
-				"error":   "Failed to render invoice.",
+				"error":   "Failed to load credit note PDF.",
 				"details": err.Error(),
-				"path":    previewTemplatePath,
 			})
 		}
+		linkInvoiceEmailLog(app, note, logRec)
+
+		var noteView invoiceViewModel
+		_ = note.UnmarshalJSONField("snapshot", &noteView)
+		view := buildEmailViewModel(payload)
+		view.Message = opts.Message
+		view.Invoice = &noteView
 
-		// render pdf
-		pdfStart := time.Now()
-		pdfBytes, err := renderInvoicePdf(html)
+		rendered, err := renderEmailTemplate(app, filepath.Dir(previewTemplatePath), logCtx.TemplateKey, "email.credit_note", view)
 		if err != nil {
 			updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
-				"stage":    "render_pdf",
-				"pdfMs":    time.Since(pdfStart).Milliseconds(),
-				"pdfBytes": 0,
+				"stage": "render_email",
 			})
 			return e.JSON(http.StatusInternalServerError, map[string]any{
 				"ok":      false,
-				"error":   "Failed to generate invoice PDF.",
+				"error":   "Failed to render credit note email.",
 				"details": err.Error(),
 			})
 		}
+		rendered.Subject = firstNonEmpty(opts.Subject, rendered.Subject)
+		recordRenderedEmail(logRec, rendered)
 
 		from := mail.Address{
 			Address: app.Settings().Meta.SenderAddress,
@@ -89,43 +149,49 @@ func registerEmailRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, previe
 		msg := &mailer.Message{
 			From:    from,
 			To:      to,
-			Subject: subject,
-			HTML: fmt.Sprintf(
-				"<p>Hi %s,</p><p>Please find your invoice attached.</p>",
-				firstNonEmpty(payload.Customer.FirstName, "there"),
-			),
-			Text: fmt.Sprintf(
-				"Hi %s,\n\nPlease find your invoice attached.\n",
-				firstNonEmpty(payload.Customer.FirstName, "there"),
-			),
+			Subject: firstNonEmpty(rendered.Subject, subject),
+			HTML:    rendered.HTML,
+			Text:    rendered.Text,
 			Attachments: map[string]io.Reader{
-				"invoice.pdf": bytes.NewReader(pdfBytes),
+				"credit-note.pdf": bytes.NewReader(pdfBytes),
 			},
 		}
+		opts.apply(msg)
 
-		sendStart := time.Now()
-		if err := app.NewMailClient().Send(msg); err != nil {
-			updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
-				"stage":    "send_email",
-				"sendMs":   time.Since(sendStart).Milliseconds(),
-				"pdfBytes": len(pdfBytes),
-			})
+		outbox, err := queueEmailForLog(app, logRec, msg, map[string]any{
+			"pdfBytes": len(pdfBytes),
+		})
+		if err != nil {
 			return e.JSON(http.StatusInternalServerError, map[string]any{
 				"ok":      false,
-				"error":   "Failed to send invoice email.",
+				"error":   "Failed to queue credit note email.",
 				"details": err.Error(),
 			})
 		}
 
-		updateEmailLog(app, logRec, "sent", "", map[string]any{
-			"stage":    "sent",
-			"sendMs":   time.Since(sendStart).Milliseconds(),
-			"pdfBytes": len(pdfBytes),
-		})
+		return e.JSON(http.StatusOK, map[string]any{"ok": true, "queued": true, "outboxId": outbox.Id})
+	}).Bind(apis.RequireAuth())
+
+	// what the customer would receive, without sending or logging anything
+	se.Router.POST("/api/email/preview", func(e *core.RequestEvent) error {
+		return handleEmailPreview(app, e, previewTemplatePath)
+	}).Bind(apis.RequireAuth())
 
-		return e.JSON(http.StatusOK, map[string]any{"ok": true})
+	// dryRun returns the recipients and rendered previews without queueing anything
+	se.Router.POST("/api/email/bulk", func(e *core.RequestEvent) error {
+		return handleBulkEmail(app, e, previewTemplatePath)
 	}).Bind(apis.RequireAuth())
 
+	// inbound webhook for customer replies; authenticated by INBOUND_EMAIL_SECRET, not a user token
+	se.Router.POST("/api/email/inbound", func(e *core.RequestEvent) error {
+		return handleInboundEmail(app, e)
+	})
+
+	// provider delivery events (delivered, bounced, complained, opened); HMAC signed with EMAIL_EVENTS_SECRET
+	se.Router.POST("/api/email/events", func(e *core.RequestEvent) error {
+		return handleEmailEvents(app, e)
+	})
+
 	se.Router.POST("/api/email/recommendation", func(e *core.RequestEvent) error {
 		var payload invoicePayload
 		if err := bindPayload(e, &payload); err != nil {
@@ -143,11 +209,18 @@ func registerEmailRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, previe
 			})
 		}
 
-		// default subject; FE can override later by adding it to payload if you want
+		opts, err := resolveEmailOptions(payload.EmailContext)
+		if err != nil {
+			return respondEmailOptionsError(e, err)
+		}
+
+		// fallback only; the template title wins
 		subject := "Your bouquet recommendation"
 
 		// log attempt
-		logCtx, meta := buildEmailLogContextFromPayload(payload, "recommendation_bouquet", "manual", "recommendation")
+		logCtx, meta := buildEmailLogContextFromPayload(payload, "recommendation_bouquet", "manual", "email.recommendation")
+		meta = mergeMeta(meta, opts.meta())
+		subject = firstNonEmpty(opts.Subject, subject)
 		toName := buildCustomerDisplayName(payload)
 
 		var logRec *core.Record
@@ -157,45 +230,199 @@ func registerEmailRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, previe
 			fmt.Println("email log create failed:", err.Error())
 		}
 
+		images, err := loadFrameReferenceImages(app, payload.Frames)
+		if err != nil {
+			updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
+				"stage": "reference_images",
+			})
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to load reference images.",
+				"details": err.Error(),
+			})
+		}
+
+		view := buildEmailViewModel(payload)
+		view.Message = opts.Message
+		view.Recommendation = buildEmailRecommendation(payload)
+		view.Recommendation.Photos = len(images)
+
+		rendered, err := renderEmailTemplate(app, filepath.Dir(previewTemplatePath), logCtx.TemplateKey, "email.recommendation", view)
+		if err != nil {
+			updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
+				"stage": "render_email",
+			})
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to render recommendation email.",
+				"details": err.Error(),
+			})
+		}
+		rendered.Subject = firstNonEmpty(opts.Subject, rendered.Subject)
+		recordRenderedEmail(logRec, rendered)
+
 		from := mail.Address{
 			Address: app.Settings().Meta.SenderAddress,
 			Name:    app.Settings().Meta.SenderName,
 		}
 		to := []mail.Address{{Address: payload.Customer.Email}}
 
-		// Keep simple for now; once we hook FE eventType, you can select templates/body copy.
 		msg := &mailer.Message{
 			From:    from,
 			To:      to,
-			Subject: subject,
-			HTML: fmt.Sprintf(
-				"<p>Hi %s,</p><p>Your recommendation is ready. If you have any questions, reply to this email.</p>",
-				firstNonEmpty(payload.Customer.FirstName, "there"),
-			),
-			Text: fmt.Sprintf(
-				"Hi %s,\n\nYour recommendation is ready.\n",
-				firstNonEmpty(payload.Customer.FirstName, "there"),
-			),
-		}
-
-		sendStart := time.Now()
-		if err := app.NewMailClient().Send(msg); err != nil {
-			updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
-				"stage":  "send_email",
-				"sendMs": time.Since(sendStart).Milliseconds(),
-			})
+			Subject: firstNonEmpty(rendered.Subject, subject),
+			HTML:    rendered.HTML,
+			Text:    rendered.Text,
+		}
+		if len(images) > 0 {
+			msg.Attachments = make(map[string]io.Reader, len(images))
+			for _, image := range images {
+				msg.Attachments[image.Name] = bytes.NewReader(image.Data)
+			}
+		}
+		opts.apply(msg)
+
+		outbox, err := queueEmailForLog(app, logRec, msg, map[string]any{"referenceImages": len(images)})
+		if err != nil {
 			return e.JSON(http.StatusInternalServerError, map[string]any{
 				"ok":      false,
-				"error":   "Failed to send recommendation email.",
+				"error":   "Failed to queue recommendation email.",
 				"details": err.Error(),
 			})
 		}
 
-		updateEmailLog(app, logRec, "sent", "", map[string]any{
-			"stage":  "sent",
-			"sendMs": time.Since(sendStart).Milliseconds(),
+		return e.JSON(http.StatusOK, map[string]any{"ok": true, "queued": true, "outboxId": outbox.Id})
+	}).Bind(apis.RequireAuth())
+}
+
+func sendInvoiceEmail(app *pocketbase.PocketBase, e *core.RequestEvent, payload invoicePayload, previewTemplatePath string) error {
+	if strings.TrimSpace(payload.Customer.Email) == "" {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":    false,
+			"error": "Missing customer email.",
+		})
+	}
+
+	opts, err := resolveEmailOptions(payload.EmailContext)
+	if err != nil {
+		return respondEmailOptionsError(e, err)
+	}
+
+	// a stale tab must not email an invoice whose rows don't add up
+	if mismatches := compareClientTotals(payload, computeInvoiceTotals(payload)); len(mismatches) > 0 {
+		return e.JSON(http.StatusConflict, map[string]any{
+			"ok":         false,
+			"error":      "Invoice totals are out of date. Please refresh the order and try again.",
+			"mismatches": mismatches,
+		})
+	}
+
+	// fallback only; the template title has the invoice number, which isn't known until it's issued
+	subject := "Invoice"
+
+	// create log entry (attempted) - best effort
+	logCtx, meta := buildEmailLogContextFromPayload(payload, "invoice", "manual", "email.invoice")
+	meta = mergeMeta(meta, opts.meta())
+	subject = firstNonEmpty(opts.Subject, subject)
+	toName := buildCustomerDisplayName(payload)
+
+	var logRec *core.Record
+	if rec, err := createEmailLog(app, e, payload.Customer.Email, toName, subject, logCtx, meta); err == nil {
+		logRec = rec
+	} else {
+		fmt.Println("email log create failed:", err.Error())
+	}
+
+	// render (or re-use) the invoice document
+	pdfStart := time.Now()
+	doc, err := resolveInvoiceDocument(app, e, payload, previewTemplatePath, payload.IssueNewVersion)
+	if err != nil {
+		stage := "render_html"
+		if stageErr, ok := err.(*invoiceStageError); ok {
+			stage = stageErr.Stage
+		}
+		updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
+			"stage":    stage,
+			"pdfMs":    time.Since(pdfStart).Milliseconds(),
+			"pdfBytes": 0,
+		})
+
+		message := "Failed to render invoice."
+		switch stage {
+		case "render_pdf":
+			message = "Failed to generate invoice PDF."
+		case "load_invoice", "store_invoice":
+			message = "Failed to store invoice."
+		case "load_payments":
+			message = "Failed to load payments."
+		}
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   message,
+			"details": err.Error(),
+			"path":    previewTemplatePath,
 		})
+	}
+	pdfBytes := doc.PDF
 
-		return e.JSON(http.StatusOK, map[string]any{"ok": true})
-	}).Bind(apis.RequireAuth())
+	invoiceMeta := map[string]any{"invoiceReused": doc.Reused}
+	if doc.Record != nil {
+		invoiceMeta["invoiceId"] = doc.Record.Id
+		invoiceMeta["invoiceVersion"] = doc.Record.GetInt("version")
+	}
+	linkInvoiceEmailLog(app, doc.Record, logRec)
+
+	view := buildEmailViewModel(payload)
+	view.Message = opts.Message
+	view.Invoice = &doc.Invoice
+
+	rendered, err := renderEmailTemplate(app, filepath.Dir(previewTemplatePath), logCtx.TemplateKey, "email.invoice", view)
+	if err != nil {
+		updateEmailLog(app, logRec, "failed", err.Error(), mergeMeta(invoiceMeta, map[string]any{
+			"stage": "render_email",
+		}))
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to render invoice email.",
+			"details": err.Error(),
+		})
+	}
+	rendered.Subject = firstNonEmpty(opts.Subject, rendered.Subject)
+	recordRenderedEmail(logRec, rendered)
+
+	from := mail.Address{
+		Address: app.Settings().Meta.SenderAddress,
+		Name:    app.Settings().Meta.SenderName,
+	}
+	to := []mail.Address{{Address: payload.Customer.Email}}
+
+	msg := &mailer.Message{
+		From:    from,
+		To:      to,
+		Subject: firstNonEmpty(rendered.Subject, subject),
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+		Attachments: map[string]io.Reader{
+			"invoice.pdf": bytes.NewReader(pdfBytes),
+		},
+	}
+	opts.apply(msg)
+
+	outbox, err := queueEmailForLog(app, logRec, msg, mergeMeta(invoiceMeta, map[string]any{
+		"pdfBytes": len(pdfBytes),
+	}))
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to queue invoice email.",
+			"details": err.Error(),
+		})
+	}
+
+	result := map[string]any{"ok": true, "queued": true, "outboxId": outbox.Id, "invoiceReused": doc.Reused}
+	if doc.Record != nil {
+		result["invoiceId"] = doc.Record.Id
+		result["invoiceVersion"] = doc.Record.GetInt("version")
+	}
+	return e.JSON(http.StatusOK, result)
 }
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const testPreviewTemplatePath = "pb_hooks/views/invoice.preview.html"
+
+const testOrderId = "order0000000001"
+
+const testInvoiceBody = `{
+	"emailContext": {"orderId": "` + testOrderId + `"},
+	"customer": {"firstName": "Jane", "surname": "Doe", "email": "jane@example.com"},
+	"order": {"orderNo": 1234, "occasionDate": "2026-06-01"},
+	"frames": [{"size": "12x12", "frameType": "Oak", "price": 250}]
+}`
+
+type failingPdfRenderer struct{}
+
+func (r *failingPdfRenderer) Name() string { return "failing" }
+
+func (r *failingPdfRenderer) Render(string, invoiceViewModel) ([]byte, error) {
+	return nil, errors.New("renderer unavailable")
+}
+
+func testMailClient(t testing.TB, app core.App) *fakeMailClient {
+	client, ok := currentMailClient(app).(*fakeMailClient)
+	if !ok {
+		t.Fatal("expected the fake mail client")
+	}
+	return client
+}
+
+func bindEmailRoutes(previewTemplatePath string) func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+	return func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+		registerEmailRoutes(e, &pocketbase.PocketBase{App: app}, previewTemplatePath)
+	}
+}
+
+// bindEmailRoutesWithOrder also creates the order testInvoiceBody refers to, so invoices are stored.
+func bindEmailRoutesWithOrder(previewTemplatePath string) func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+	return func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+		coll, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		order := core.NewRecord(coll)
+		order.Id = testOrderId
+		order.Set("orderNo", 1234)
+		if err := app.Save(order); err != nil {
+			t.Fatal(err)
+		}
+
+		registerEmailRoutes(e, &pocketbase.PocketBase{App: app}, previewTemplatePath)
+	}
+}
+
+func assertInvoiceCount(t testing.TB, app core.App, expected int) {
+	t.Helper()
+
+	total, err := app.CountRecords("invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(total) != expected {
+		t.Errorf("expected %d invoices, got %d", expected, total)
+	}
+}
+
+// drainEmailOutbox runs the outbox worker once, synchronously.
+func drainEmailOutbox(app core.App) {
+	(&emailOutboxWorker{app: app}).processDue(context.Background())
+}
+
+func findOnlyEmailLog(t testing.TB, app core.App, emailType string) *core.Record {
+	logs, err := app.FindRecordsByFilter("email_logs", "emailType = {:type}", "", 0, 0, dbx.Params{"type": emailType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 %s email log, got %d", emailType, len(logs))
+	}
+	return logs[0]
+}
+
+func assertEmailLog(t testing.TB, logRec *core.Record, status string, stage string) {
+	t.Helper()
+
+	if got := logRec.GetString("status"); got != status {
+		t.Errorf("expected log status %q, got %q (error %q)", status, got, logRec.GetString("error"))
+	}
+	if got := readEmailLogMeta(logRec)["stage"]; got != stage {
+		t.Errorf("expected log stage %q, got %v", stage, got)
+	}
+}
+
+func assertOutboxCount(t testing.TB, app core.App, expected int) {
+	t.Helper()
+
+	total, err := app.CountRecords("email_outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(total) != expected {
+		t.Errorf("expected %d outbox rows, got %d", expected, total)
+	}
+}
+
+func TestInvoiceEmailRoute(t *testing.T) {
+	auth := superuserAuthHeader(t)
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "requires auth",
+			Method:          http.MethodPost,
+			URL:             "/api/email/invoice",
+			Body:            strings.NewReader(testInvoiceBody),
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutes(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "invalid payload",
+			Method:          http.MethodPost,
+			URL:             "/api/email/invoice",
+			Body:            strings.NewReader(`{"customer":`),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutes(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"ok":false`, `"error":"Invalid payload."`},
+		},
+		{
+			Name:            "missing customer email",
+			Method:          http.MethodPost,
+			URL:             "/api/email/invoice",
+			Body:            strings.NewReader(`{"customer":{"firstName":"Jane"}}`),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutes(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"error":"Missing customer email."`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				assertOutboxCount(t, app, 0)
+			},
+		},
+		{
+			Name:            "invalid cc",
+			Method:          http.MethodPost,
+			URL:             "/api/email/invoice",
+			Body:            strings.NewReader(`{"customer":{"email":"jane@example.com"},"emailContext":{"cc":["not an address"]}}`),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutes(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"error":"Invalid email options."`, `"field":"cc"`},
+		},
+		{
+			Name:            "stale client totals",
+			Method:          http.MethodPost,
+			URL:             "/api/email/invoice",
+			Body:            strings.NewReader(`{"customer":{"email":"jane@example.com"},"frames":[{"price":250}],"totals":{"subTotal":1,"grandTotal":1}}`),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutes(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"mismatches":[`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				assertOutboxCount(t, app, 0)
+			},
+		},
+		{
+			Name:            "queued and sent with the invoice attached",
+			Method:          http.MethodPost,
+			URL:             "/api/email/invoice",
+			Body:            strings.NewReader(testInvoiceBody),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutesWithOrder(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"ok":true`, `"queued":true`, `"outboxId":"`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				logRec := findOnlyEmailLog(t, app, "invoice")
+				assertEmailLog(t, logRec, "queued", "queued")
+				if logRec.GetString("toEmail") != "jane@example.com" {
+					t.Errorf("expected toEmail jane@example.com, got %q", logRec.GetString("toEmail"))
+				}
+				assertOutboxCount(t, app, 1)
+
+				drainEmailOutbox(app)
+
+				logRec = findOnlyEmailLog(t, app, "invoice")
+				assertEmailLog(t, logRec, "sent", "sent")
+
+				sent := testMailClient(t, app).Messages()
+				if len(sent) != 1 {
+					t.Fatalf("expected 1 sent email, got %d", len(sent))
+				}
+				msg := sent[0].Message
+				if len(msg.To) != 1 || msg.To[0].Address != "jane@example.com" {
+					t.Errorf("unexpected recipients %v", msg.To)
+				}
+				if msg.Subject != "Invoice INV-0001" {
+					t.Errorf("expected the invoice number in the subject, got %q", msg.Subject)
+				}
+				if msg.Headers["Message-ID"] != "<"+logRec.GetString("messageId")+">" {
+					t.Errorf("expected Message-ID %q to match the log's %q", msg.Headers["Message-ID"], logRec.GetString("messageId"))
+				}
+				if pdf := sent[0].Attachments["invoice.pdf"]; !bytes.HasPrefix(pdf, []byte("%PDF")) {
+					t.Errorf("expected invoice.pdf attachment, got %d bytes", len(pdf))
+				}
+
+				invoice, err := app.FindFirstRecordByData("invoices", "orderId", testOrderId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if invoice.GetString("invoiceNo") != "INV-0001" || invoice.GetInt("sequence") != 1 {
+					t.Errorf("expected INV-0001 (sequence 1), got %q (%d)", invoice.GetString("invoiceNo"), invoice.GetInt("sequence"))
+				}
+			},
+		},
+		{
+			Name:            "render_html failure",
+			Method:          http.MethodPost,
+			URL:             "/api/email/invoice",
+			Body:            strings.NewReader(testInvoiceBody),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutesWithOrder("pb_hooks/views/missing.html"),
+			ExpectedStatus:  http.StatusInternalServerError,
+			ExpectedContent: []string{`"error":"Failed to render invoice."`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				assertEmailLog(t, findOnlyEmailLog(t, app, "invoice"), "failed", "render_html")
+				assertOutboxCount(t, app, 0)
+				assertInvoiceCount(t, app, 0)
+				if no := peekInvoiceNo(app, time.Now()); no != "INV-0001" {
+					t.Errorf("expected the failed issue not to use up a number, next is %q", no)
+				}
+			},
+		},
+		{
+			Name:           "render_pdf failure",
+			Method:         http.MethodPost,
+			URL:            "/api/email/invoice",
+			Body:           strings.NewReader(testInvoiceBody),
+			Headers:        auth,
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				setPdfRenderer(&failingPdfRenderer{})
+				bindEmailRoutesWithOrder(testPreviewTemplatePath)(t, app, e)
+			},
+			ExpectedStatus:  http.StatusInternalServerError,
+			ExpectedContent: []string{`"error":"Failed to generate invoice PDF."`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				logRec := findOnlyEmailLog(t, app, "invoice")
+				assertEmailLog(t, logRec, "failed", "render_pdf")
+				if !strings.Contains(logRec.GetString("error"), "renderer unavailable") {
+					t.Errorf("expected the renderer error on the log, got %q", logRec.GetString("error"))
+				}
+				assertOutboxCount(t, app, 0)
+			},
+		},
+		{
+			Name:           "send_email failure after the last retry",
+			Method:         http.MethodPost,
+			URL:            "/api/email/invoice",
+			Body:           strings.NewReader(testInvoiceBody),
+			Headers:        auth,
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				testMailClient(t, app).Err = errors.New("smtp unavailable")
+				bindEmailRoutesWithOrder(testPreviewTemplatePath)(t, app, e)
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"queued":true`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				drainEmailOutbox(app)
+				assertEmailLog(t, findOnlyEmailLog(t, app, "invoice"), "queued", "send_retry")
+
+				// skip ahead to the final attempt
+				outbox, err := app.FindFirstRecordByFilter("email_outbox", "id != ''")
+				if err != nil {
+					t.Fatal(err)
+				}
+				outbox.Set("attempts", outboxMaxAttempts-1)
+				outbox.Set("nextAttemptAt", types.NowDateTime())
+				if err := app.Save(outbox); err != nil {
+					t.Fatal(err)
+				}
+
+				drainEmailOutbox(app)
+
+				logRec := findOnlyEmailLog(t, app, "invoice")
+				assertEmailLog(t, logRec, "failed", "send_email")
+				if logRec.GetString("error") != "smtp unavailable" {
+					t.Errorf("expected the send error on the log, got %q", logRec.GetString("error"))
+				}
+				if len(testMailClient(t, app).Messages()) != 0 {
+					t.Error("expected nothing to be sent")
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestEmailPreviewRoute(t *testing.T) {
+	auth := superuserAuthHeader(t)
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "stale client totals",
+			Method:          http.MethodPost,
+			URL:             "/api/email/preview",
+			Body:            strings.NewReader(`{"customer":{"email":"jane@example.com"},"frames":[{"price":250}],"totals":{"subTotal":1,"grandTotal":1}}`),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutes(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"mismatches":[`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				assertInvoiceCount(t, app, 0)
+			},
+		},
+		{
+			Name:            "invoice email",
+			Method:          http.MethodPost,
+			URL:             "/api/email/preview",
+			Body:            strings.NewReader(testInvoiceBody),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutesWithOrder(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"ok":true`, `"templateKey":"email.invoice"`, `"name":"invoice.pdf"`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				assertInvoiceCount(t, app, 0)
+				assertOutboxCount(t, app, 0)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestRecommendationEmailRoute(t *testing.T) {
+	auth := superuserAuthHeader(t)
+
+	const frameItemId = "frameitem000001"
+
+	body := `{
+		"customer": {"firstName": "Jane", "email": "jane@example.com"},
+		"emailContext": {"emailType": "recommendation_bouquet", "eventType": "manual"},
+		"frames": [{
+			"frameId": "` + frameItemId + `",
+			"frameType": "Oak",
+			"mountColour": "white",
+			"layout": "Cascade",
+			"extras": {"framePrice": 200, "mountPrice": 30, "recommendedSizeWidthIn": 12, "recommendedSizeHeightIn": 16}
+		}]
+	}`
+
+	createFrameItemWithImage := func(t testing.TB, app *tests.TestApp) {
+		coll, err := app.FindCollectionByNameOrId("order_frame_items")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+			t.Fatal(err)
+		}
+		file, err := filesystem.NewFileFromBytes(buf.Bytes(), "bouquet.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		frame := core.NewRecord(coll)
+		frame.Id = frameItemId
+		frame.Set("referenceImages", []*filesystem.File{file})
+		if err := app.Save(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "missing customer email",
+			Method:          http.MethodPost,
+			URL:             "/api/email/recommendation",
+			Body:            strings.NewReader(`{"customer":{"firstName":"Jane"}}`),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutes(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"error":"Missing customer email."`},
+		},
+		{
+			Name:            "invalid reply-to",
+			Method:          http.MethodPost,
+			URL:             "/api/email/recommendation",
+			Body:            strings.NewReader(`{"customer":{"email":"jane@example.com"},"emailContext":{"replyTo":"nope"}}`),
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindEmailRoutes(testPreviewTemplatePath),
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"field":"replyTo"`},
+		},
+		{
+			Name:           "queued and sent with reference images attached",
+			Method:         http.MethodPost,
+			URL:            "/api/email/recommendation",
+			Body:           strings.NewReader(body),
+			Headers:        auth,
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				createFrameItemWithImage(t, app)
+				bindEmailRoutes(testPreviewTemplatePath)(t, app, e)
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"ok":true`, `"queued":true`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				logRec := findOnlyEmailLog(t, app, "recommendation_bouquet")
+				assertEmailLog(t, logRec, "queued", "queued")
+				if images := readEmailLogMeta(logRec)["referenceImages"]; images != float64(1) {
+					t.Errorf("expected referenceImages 1 in meta, got %v", images)
+				}
+
+				drainEmailOutbox(app)
+
+				assertEmailLog(t, findOnlyEmailLog(t, app, "recommendation_bouquet"), "sent", "sent")
+
+				sent := testMailClient(t, app).Messages()
+				if len(sent) != 1 {
+					t.Fatalf("expected 1 sent email, got %d", len(sent))
+				}
+				if !strings.Contains(sent[0].Message.HTML, "Cascade") {
+					t.Error("expected the frame layout in the email body")
+				}
+				if len(sent[0].Attachments) != 1 {
+					t.Fatalf("expected 1 attachment, got %d", len(sent[0].Attachments))
+				}
+				for name, data := range sent[0].Attachments {
+					if !strings.HasPrefix(name, "item1-") || !bytes.HasPrefix(data, []byte("\x89PNG")) {
+						t.Errorf("unexpected attachment %q (%d bytes)", name, len(data))
+					}
+				}
+			},
+		},
+		{
+			Name:           "send_email failure after the last retry",
+			Method:         http.MethodPost,
+			URL:            "/api/email/recommendation",
+			Body:           strings.NewReader(body),
+			Headers:        auth,
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				createFrameItemWithImage(t, app)
+				testMailClient(t, app).Err = errors.New("smtp unavailable")
+				bindEmailRoutes(testPreviewTemplatePath)(t, app, e)
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"queued":true`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				outbox, err := app.FindFirstRecordByFilter("email_outbox", "id != ''")
+				if err != nil {
+					t.Fatal(err)
+				}
+				outbox.Set("attempts", outboxMaxAttempts-1)
+				if err := app.Save(outbox); err != nil {
+					t.Fatal(err)
+				}
+
+				drainEmailOutbox(app)
+
+				assertEmailLog(t, findOnlyEmailLog(t, app, "recommendation_bouquet"), "failed", "send_email")
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
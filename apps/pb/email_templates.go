@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// email_templates rows override the email.<key>.html files on disk. Each row keeps the
+// three parts separately, and each is parsed as its own template under the name the files
+// define ("title", "body", "text"), so both sources render through executeEmailTemplate.
+
+func findStoredEmailTemplate(app core.App, key string) (*core.Record, error) {
+	records, err := app.FindRecordsByFilter(
+		"email_templates",
+		"templateKey = {:key} && active = true",
+		"",
+		1,
+		0,
+		dbx.Params{"key": key},
+	)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+func storedEmailTemplateContent(subject, html, text string) emailTemplateContent {
+	parts := map[string]string{"title": subject, "body": html}
+	if strings.TrimSpace(text) != "" {
+		parts["text"] = text
+	}
+	return emailTemplateContent{Parts: parts}
+}
+
+// parseEmailTemplateParts parses each stored part on its own. A part can't define other
+// templates: Go lets a later define replace an earlier one, so the html could swap the subject.
+func parseEmailTemplateParts(parts map[string]string) (map[string]*parse.Tree, error) {
+	trees := make(map[string]*parse.Tree, len(parts))
+	for name, src := range parts {
+		tmpl, err := texttemplate.New(name).Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		for _, defined := range tmpl.Templates() {
+			if defined.Name() != name {
+				return nil, fmt.Errorf("%s: {{define}} and {{block}} aren't allowed in a stored template", name)
+			}
+		}
+		trees[name] = tmpl.Tree
+	}
+	return trees, nil
+}
+
+func loadEmailLayout(viewsDir string) (string, error) {
+	layout, err := os.ReadFile(filepath.Join(viewsDir, emailLayoutTemplate))
+	if err != nil {
+		return "", fmt.Errorf("email layout not found: %w", err)
+	}
+	return string(layout), nil
+}
+
+// sampleInvoicePayload is what stored templates are test-rendered against on save.
+func sampleInvoicePayload() invoicePayload {
+	var payload invoicePayload
+	_ = json.Unmarshal([]byte(`{
+		"customer": {"title": "Mrs", "firstName": "Jane", "surname": "Sample", "email": "jane@example.com"},
+		"order": {
+			"orderNo": 1001,
+			"occasionDate": "2026-06-20",
+			"billingAddressLine1": "1 Example Street",
+			"billingTown": "Basingstoke",
+			"billingPostcode": "RG21 1AA"
+		},
+		"orderExtras": {"deliveryQty": 1, "deliveryPrice": 25, "notes": "Sample notes"},
+		"frames": [{
+			"size": "12x14 inches",
+			"layout": "Hand tied side profile",
+			"frameType": "Oak",
+			"glassType": "Conservation glass",
+			"mountColour": "Cream - 8674",
+			"price": 395,
+			"extras": {
+				"framePrice": 360,
+				"mountPrice": 35,
+				"measuredWidthIn": 10,
+				"measuredHeightIn": 12.5,
+				"recommendedSizeWidthIn": 14,
+				"recommendedSizeHeightIn": 16
+			}
+		}]
+	}`), &payload)
+	return payload
+}
+
+// sampleEmailViewModel fills every optional section so templates can't hide a bad field behind an {{if}}.
+func sampleEmailViewModel(key string) emailViewModel {
+	payload := sampleInvoicePayload()
+	invoice := buildInvoiceViewModel(payload, []invoicePayment{
+		{Kind: "first_deposit", Method: "bank_transfer", PaidAt: "2026-01-15", Amount: 100},
+	})
+	invoice.InvoiceNo = formatSequenceNumber(resolveInvoiceNumbering().Format, 1, time.Now())
+	if key == "email.credit_note" {
+		invoice.Title = "CREDIT NOTE"
+		invoice.DocumentNoLabel = "Credit Note No"
+		invoice.IsCreditNote = true
+		invoice.CreditedInvoiceNo = invoice.InvoiceNo
+		invoice.InvoiceNo = formatCreditNoteNo(1)
+		invoice.Reason = "Sample reason"
+	}
+
+	view := buildEmailViewModel(payload)
+	view.Invoice = &invoice
+	view.Recommendation = buildEmailRecommendation(payload)
+	view.Recommendation.SageMountPrice = formatMoney(35)
+	view.Recommendation.SideProfileUpsizePrice = formatMoney(60)
+	view.Recommendation.Photos = 2
+	view.Links = emailLinks{
+		OrderForm:   "https://example.com/order-form",
+		FrameStyles: "https://example.com/frame-styles",
+		Terms:       "https://example.com/terms",
+	}
+	view.Brand.Website = "https://example.com"
+	view.Status = &emailStatusUpdate{
+		Event:      "framing_complete",
+		FromStatus: orderStatusLabels["in_progress"],
+		ToStatus:   orderStatusLabels["ready"],
+		FrameLabel: "Item 1",
+	}
+	view.Message = "Sample message from the studio."
+	view.Reminder = &emailPaymentReminder{
+		Stage:      "first_deposit",
+		StageLabel: "first deposit",
+		AmountDue:  invoice.BalanceDue,
+	}
+
+	return view
+}
+
+// validateEmailTemplateRecord parses and test-renders each part, reporting errors per field.
+func validateEmailTemplateRecord(viewsDir string, rec *core.Record) error {
+	layout, err := loadEmailLayout(viewsDir)
+	if err != nil {
+		return err
+	}
+
+	key := rec.GetString("templateKey")
+	view := sampleEmailViewModel(key)
+
+	parts := map[string]emailTemplateContent{
+		"subject": {Parts: map[string]string{"title": rec.GetString("subject")}},
+		"html":    {Parts: map[string]string{"body": rec.GetString("html")}},
+		"text":    {Parts: map[string]string{"text": rec.GetString("text")}},
+	}
+
+	errs := validation.Errors{}
+	for field, content := range parts {
+		if _, err := executeEmailTemplate(key, layout, content, view); err != nil {
+			errs[field] = validation.NewError("validation_invalid_email_template", err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// latestEmailTemplateVersion returns the newest history row, or nil for a template never saved.
+func latestEmailTemplateVersion(app core.App, templateId string) (*core.Record, error) {
+	records, err := app.FindRecordsByFilter(
+		"email_template_versions",
+		"templateId = {:templateId}",
+		"-version",
+		1,
+		0,
+		dbx.Params{"templateId": templateId},
+	)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+func emailTemplateContentChanged(rec *core.Record, latest *core.Record) bool {
+	if latest == nil {
+		return true
+	}
+	for _, field := range []string{"subject", "html", "text"} {
+		if rec.GetString(field) != latest.GetString(field) {
+			return true
+		}
+	}
+	return false
+}
+
+func saveEmailTemplateVersion(app core.App, rec *core.Record) error {
+	coll, err := app.FindCollectionByNameOrId("email_template_versions")
+	if err != nil {
+		return err
+	}
+
+	version := core.NewRecord(coll)
+	version.Set("templateId", rec.Id)
+	version.Set("templateKey", rec.GetString("templateKey"))
+	version.Set("version", rec.GetInt("version"))
+	version.Set("subject", rec.GetString("subject"))
+	version.Set("html", rec.GetString("html"))
+	version.Set("text", rec.GetString("text"))
+	version.Set("updatedBy", rec.GetString("updatedBy"))
+
+	return app.Save(version)
+}
+
+func registerEmailTemplateHooks(app *pocketbase.PocketBase) {
+	setUpdatedBy := func(e *core.RecordRequestEvent) error {
+		if e.Auth != nil && e.Auth.Collection().Name == "users" {
+			e.Record.Set("updatedBy", e.Auth.Id)
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("email_templates").BindFunc(setUpdatedBy)
+	app.OnRecordUpdateRequest("email_templates").BindFunc(setUpdatedBy)
+
+	app.OnRecordValidate("email_templates").BindFunc(func(e *core.RecordEvent) error {
+		e.Record.Set("templateKey", normalizeEmailTemplateKey(e.Record.GetString("templateKey")))
+
+		// built-in field validation first, so required/pattern errors come back as usual
+		if err := e.Next(); err != nil {
+			return err
+		}
+		return validateEmailTemplateRecord(resolvePathFromExecutable("pb_hooks", "views"), e.Record)
+	})
+
+	// the history table is the source of truth for version numbers, so a client
+	// can't skip or reuse one by sending "version" itself
+	app.OnRecordCreate("email_templates").BindFunc(func(e *core.RecordEvent) error {
+		e.Record.Set("version", 1)
+		if err := e.Next(); err != nil {
+			return err
+		}
+		return saveEmailTemplateVersion(e.App, e.Record)
+	})
+
+	app.OnRecordUpdate("email_templates").BindFunc(func(e *core.RecordEvent) error {
+		latest, err := latestEmailTemplateVersion(e.App, e.Record.Id)
+		if err != nil {
+			return err
+		}
+
+		changed := emailTemplateContentChanged(e.Record, latest)
+		switch {
+		case changed && latest != nil:
+			e.Record.Set("version", latest.GetInt("version")+1)
+		case changed:
+			e.Record.Set("version", 1)
+		default:
+			e.Record.Set("version", latest.GetInt("version"))
+		}
+
+		if err := e.Next(); err != nil {
+			return err
+		}
+		if !changed {
+			return nil
+		}
+		return saveEmailTemplateVersion(e.App, e.Record)
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const testEmailViewsDir = "pb_hooks/views"
+
+func newEmailTemplateRecord(t testing.TB, app core.App, key, subject, html, text string) *core.Record {
+	coll, err := app.FindCollectionByNameOrId("email_templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := core.NewRecord(coll)
+	rec.Set("templateKey", key)
+	rec.Set("subject", subject)
+	rec.Set("html", html)
+	rec.Set("text", text)
+	rec.Set("active", true)
+	return rec
+}
+
+func TestEmailTemplateValidation(t *testing.T) {
+	app := newTestApp(t, registerEmailTemplateHooks)
+
+	scenarios := []struct {
+		name    string
+		subject string
+		html    string
+		text    string
+		field   string // "" when the template is valid
+	}{
+		{"valid", "Invoice {{.Invoice.InvoiceNo}}", "<p>Dear {{.Customer.FirstName}}</p>", "Dear {{.Customer.FirstName}}", ""},
+		{"unknown field in the subject", "Invoice {{.Nope}}", "<p>Hi</p>", "", "subject"},
+		{"bad syntax in the html", "Invoice", "<p>{{if .Message}}</p>", "", "html"},
+		{"unknown field in the text", "Invoice", "<p>Hi</p>", "{{.Order.Nope}}", "text"},
+		// a define in one part would silently replace another once the parts are put together
+		{"html redefining the subject", "Invoice", `<p>Hi</p>{{define "title"}}Hacked{{end}}`, "", "html"},
+		{"html closing itself to redefine the subject", "Invoice", `<p>Hi</p>{{end}}{{define "title"}}Hacked`, "", "html"},
+		{"text with a block", "Invoice", "<p>Hi</p>", `{{block "body" .}}Hacked{{end}}`, "text"},
+	}
+
+	for _, s := range scenarios {
+		rec := newEmailTemplateRecord(t, app, "email.invoice", s.subject, s.html, s.text)
+		err := app.Validate(rec)
+
+		if s.field == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", s.name, err)
+			}
+			continue
+		}
+		var errs validation.Errors
+		if !errors.As(err, &errs) || errs[s.field] == nil {
+			t.Errorf("%s: expected an error on %s, got %v", s.name, s.field, err)
+		}
+	}
+}
+
+func TestEmailTemplateVersions(t *testing.T) {
+	app := newTestApp(t, registerEmailTemplateHooks)
+
+	assertVersion := func(rec *core.Record, expected int) {
+		t.Helper()
+		fresh, err := app.FindRecordById("email_templates", rec.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fresh.GetInt("version"); got != expected {
+			t.Errorf("expected version %d, got %d", expected, got)
+		}
+		history, err := app.FindRecordsByFilter("email_template_versions", "templateId = {:id}", "version", 0, 0, dbx.Params{"id": rec.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != expected {
+			t.Fatalf("expected %d history rows, got %d", expected, len(history))
+		}
+		latest := history[len(history)-1]
+		if latest.GetInt("version") != expected || latest.GetString("subject") != fresh.GetString("subject") {
+			t.Errorf("expected the latest history row to be v%d %q, got v%d %q",
+				expected, fresh.GetString("subject"), latest.GetInt("version"), latest.GetString("subject"))
+		}
+	}
+
+	rec := newEmailTemplateRecord(t, app, "email.invoice", "Invoice", "<p>Hi</p>", "")
+	rec.Set("version", 7) // set by the server, not the client
+	if err := app.Save(rec); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(rec, 1)
+
+	rec.Set("subject", "Your invoice")
+	if err := app.Save(rec); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(rec, 2)
+
+	// only content changes are versioned
+	rec.Set("description", "Sent with every invoice")
+	rec.Set("active", false)
+	if err := app.Save(rec); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(rec, 2)
+
+	// a rejected save leaves both alone
+	rec.Set("html", "<p>{{.Nope}}</p>")
+	if err := app.Save(rec); err == nil {
+		t.Fatal("expected the bad html to be rejected")
+	}
+	assertVersion(rec, 2)
+}
+
+func TestStoredEmailTemplateOverridesFile(t *testing.T) {
+	app := newTestApp(t, registerEmailTemplateHooks)
+	view := sampleEmailViewModel("email.invoice")
+
+	fromFile, err := renderEmailTemplate(app, testEmailViewsDir, "email.invoice", "", view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromFile.Source != "file" {
+		t.Fatalf("expected the file before any row is saved, got %q", fromFile.Source)
+	}
+
+	rec := newEmailTemplateRecord(t, app, "email.invoice", "Stored {{.Order.Ref}}", "<p>Stored body for {{.Customer.FirstName}}</p>", "Stored text")
+	if err := app.Save(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := renderEmailTemplate(app, testEmailViewsDir, "invoice", "", view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Source != "db:v1" || stored.Subject != "Stored #1001" || stored.Text != "Stored text\n" {
+		t.Errorf("expected the stored template, got source %q subject %q text %q", stored.Source, stored.Subject, stored.Text)
+	}
+	if !strings.Contains(stored.HTML, "<p>Stored body for Jane</p>") || !strings.Contains(stored.HTML, "<title>Stored #1001</title>") {
+		t.Errorf("expected the stored body in the layout, got %s", stored.HTML)
+	}
+
+	rec.Set("active", false)
+	if err := app.Save(rec); err != nil {
+		t.Fatal(err)
+	}
+	inactive, err := renderEmailTemplate(app, testEmailViewsDir, "email.invoice", "", view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inactive.Source != "file" || inactive.Subject != fromFile.Subject {
+		t.Errorf("expected an inactive row to fall back to the file, got %q %q", inactive.Source, inactive.Subject)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -144,12 +145,24 @@ func handleOrdersExport(app *pocketbase.PocketBase, e *core.RequestEvent) error
 		})
 	}
 
+	statusHistory, err := fetchRecordsByField(app, "order_status_history", "orderId", orderIds)
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to load status history.",
+			"details": err.Error(),
+		})
+	}
+
+	totalsByOrderId := buildOrderTotalsMap(orders, frameItems, frameItemOrderMap, paperweights, paperweightOrderMap)
+
 	file := excelize.NewFile()
 	file.SetSheetName("Sheet1", "Orders")
-	writeOrdersSheet(file, orders, customerByOrderId)
+	writeOrdersSheet(file, orders, customerByOrderId, totalsByOrderId)
 	writeFrameItemsSheet(file, frameItems, frameItemOrderMap, orderNoById)
 	writePaperweightsSheet(file, paperweights, paperweightOrderMap, orderNoById)
 	writeEmailLogsSheet(file, emailLogs)
+	writeStatusHistorySheet(file, statusHistory, orderNoById)
 
 	buffer, err := file.WriteToBuffer()
 	if err != nil {
@@ -253,11 +266,11 @@ func resolveDateRange(fromParam, toParam string) (time.Time, time.Time, error) {
 	return from, to, nil
 }
 
-func fetchRecordsByIds(app *pocketbase.PocketBase, collection string, ids []string) ([]*core.Record, error) {
+func fetchRecordsByIds(app core.App, collection string, ids []string) ([]*core.Record, error) {
 	return fetchRecordsByField(app, collection, "id", ids)
 }
 
-func fetchRecordsByField(app *pocketbase.PocketBase, collection, field string, ids []string) ([]*core.Record, error) {
+func fetchRecordsByField(app core.App, collection, field string, ids []string) ([]*core.Record, error) {
 	if len(ids) == 0 {
 		return []*core.Record{}, nil
 	}
@@ -306,10 +319,44 @@ func escapeFilterValue(value string) string {
 	return strings.ReplaceAll(value, `"`, `\"`)
 }
 
-func writeOrdersSheet(file *excelize.File, orders []*core.Record, customerByOrderId map[string]orderExportCustomer) {
+func buildOrderTotalsMap(
+	orders []*core.Record,
+	frames []*core.Record,
+	frameItemOrderMap map[string]string,
+	paperweights []*core.Record,
+	paperweightOrderMap map[string]string,
+) map[string]invoiceTotals {
+	framesByOrderId := map[string][]*core.Record{}
+	for _, frame := range frames {
+		orderId := frameItemOrderMap[frame.Id]
+		framesByOrderId[orderId] = append(framesByOrderId[orderId], frame)
+	}
+
+	paperweightByOrderId := map[string]*core.Record{}
+	for _, pw := range paperweights {
+		paperweightByOrderId[paperweightOrderMap[pw.Id]] = pw
+	}
+
+	vatRate := resolveVatRate()
+	result := make(map[string]invoiceTotals, len(orders))
+	for _, order := range orders {
+		input := pricingInputFromRecords(order, framesByOrderId[order.Id], paperweightByOrderId[order.Id])
+		result[order.Id] = computeTotals(input, vatRate)
+	}
+
+	return result
+}
+
+func writeOrdersSheet(
+	file *excelize.File,
+	orders []*core.Record,
+	customerByOrderId map[string]orderExportCustomer,
+	totalsByOrderId map[string]invoiceTotals,
+) {
 	headers := []string{
 		"orderId",
 		"orderNo",
+		"orderRef",
 		"created",
 		"updated",
 		"occasionDate",
@@ -334,6 +381,9 @@ func writeOrdersSheet(file *excelize.File, orders []*core.Record, customerByOrde
 		"returnUnusedFlowersPrice",
 		"artistHours",
 		"notes",
+		"subTotal",
+		"vatTotal",
+		"grandTotal",
 	}
 
 	writeHeaderRow(file, "Orders", headers)
@@ -341,9 +391,11 @@ func writeOrdersSheet(file *excelize.File, orders []*core.Record, customerByOrde
 	for i, order := range orders {
 		row := i + 2
 		customer := customerByOrderId[order.Id]
+		totals := totalsByOrderId[order.Id]
 		values := []any{
 			order.Id,
 			order.GetInt("orderNo"),
+			order.GetString("orderRef"),
 			exportDateDMY(order.GetString("created")),
 			exportDateDMY(order.GetString("updated")),
 			exportDateDMY(order.GetString("occasionDate")),
@@ -368,6 +420,9 @@ func writeOrdersSheet(file *excelize.File, orders []*core.Record, customerByOrde
 			exportMoneyNumber(order.GetFloat("returnUnusedFlowersPrice")),
 			order.GetString("artistHours"),
 			order.GetString("notes"),
+			exportMoneyNumber(totals.SubTotal),
+			exportMoneyNumber(totals.VatTotal),
+			exportMoneyNumber(totals.GrandTotal),
 		}
 		writeRow(file, "Orders", row, values)
 	}
@@ -397,6 +452,7 @@ func writeFrameItemsSheet(
 		"glassEngraving",
 		"artworkComplete",
 		"framingComplete",
+		"stage",
 		"preservationDate",
 		"price",
 		"framePrice",
@@ -432,6 +488,7 @@ func writeFrameItemsSheet(
 			frame.GetString("glassEngraving"),
 			frame.GetBool("artworkComplete"),
 			frame.GetBool("framingComplete"),
+			frame.GetString("stage"),
 			exportDateDMY(frame.GetString("preservationDate")),
 			exportMoneyNumber(frame.GetFloat("price")),
 			exportExtrasValue("framePrice", extras["framePrice"]),
@@ -541,6 +598,49 @@ func writeEmailLogsSheet(file *excelize.File, logs []*core.Record) {
 	}
 }
 
+func writeStatusHistorySheet(file *excelize.File, history []*core.Record, orderNoById map[string]int) {
+	sheet := "Status History"
+	file.NewSheet(sheet)
+
+	headers := []string{
+		"orderId",
+		"orderNo",
+		"changedAt",
+		"fromStatus",
+		"toStatus",
+		"changedBy",
+		"changedByEmail",
+		"reason",
+	}
+
+	writeHeaderRow(file, sheet, headers)
+
+	// oldest first within each order, so the rows read as the order's timeline
+	sort.SliceStable(history, func(i, j int) bool {
+		a, b := history[i], history[j]
+		if a.GetString("orderId") != b.GetString("orderId") {
+			return orderNoById[a.GetString("orderId")] > orderNoById[b.GetString("orderId")]
+		}
+		return a.GetDateTime("changedAt").Before(b.GetDateTime("changedAt"))
+	})
+
+	for i, entry := range history {
+		row := i + 2
+		orderId := entry.GetString("orderId")
+		values := []any{
+			orderId,
+			orderNoById[orderId],
+			entry.GetDateTime("changedAt").Time().Format("02-01-2006 15:04"),
+			entry.GetString("fromStatus"),
+			entry.GetString("toStatus"),
+			entry.GetString("changedBy"),
+			entry.GetString("changedByEmail"),
+			entry.GetString("reason"),
+		}
+		writeRow(file, sheet, row, values)
+	}
+}
+
 func writeHeaderRow(file *excelize.File, sheet string, headers []string) {
 	writeRow(file, sheet, 1, sliceAny(headers))
 }
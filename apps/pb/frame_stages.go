@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// frameStages is the workshop process for a frame, in order. A frame's stage is the last step
+// it has finished.
+var frameStages = []string{
+	"received",
+	"preserved",
+	"artwork",
+	"mounted",
+	"framed",
+	"quality_checked",
+	"collected",
+}
+
+// frameStageDateFields holds when a frame reached each stage.
+var frameStageDateFields = map[string]string{
+	"received":        "receivedAt",
+	"preserved":       "preservedAt",
+	"artwork":         "artworkAt",
+	"mounted":         "mountedAt",
+	"framed":          "framedAt",
+	"quality_checked": "qualityCheckedAt",
+	"collected":       "collectedAt",
+}
+
+var frameStageLabels = map[string]string{
+	"received":        "Received",
+	"preserved":       "Preserved",
+	"artwork":         "Artwork",
+	"mounted":         "Mounted",
+	"framed":          "Framed",
+	"quality_checked": "Quality checked",
+	"collected":       "Collected",
+}
+
+// frameStageIndex is the position of stage in frameStages. Frames saved before stages existed
+// may have none, which counts as received.
+func frameStageIndex(stage string) int {
+	return max(slices.Index(frameStages, stage), 0)
+}
+
+// registerFrameStageHooks keeps stage, its timestamps and the artworkComplete/framingComplete
+// booleans in step, and moves the parent order's status along with its frames.
+func registerFrameStageHooks(app *pocketbase.PocketBase) {
+	// passed on to the order when a stage change moves its status
+	passAuth := func(e *core.RecordRequestEvent) error {
+		e.Record.Set(orderStatusAuthKey, e.Auth)
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("order_frame_items").BindFunc(passAuth)
+	app.OnRecordUpdateRequest("order_frame_items").BindFunc(passAuth)
+
+	app.OnRecordCreate("order_frame_items").BindFunc(func(e *core.RecordEvent) error {
+		syncFrameStage(e.Record, nil, types.NowDateTime())
+		return e.Next()
+	})
+
+	// e.App is put back after the transaction so the after-success hooks (the framing complete
+	// email) don't get the committed transaction
+	app.OnRecordUpdate("order_frame_items").BindFunc(func(e *core.RecordEvent) error {
+		originalApp := e.App
+		txErr := e.App.RunInTransaction(func(txApp core.App) error {
+			e.App = txApp
+
+			// read from the db rather than Original(), which is stale when a record is saved twice
+			stored, err := txApp.FindRecordById("order_frame_items", e.Record.Id)
+			if err != nil {
+				return err
+			}
+			syncFrameStage(e.Record, stored, types.NowDateTime())
+
+			if err := e.Next(); err != nil {
+				return err
+			}
+			if e.Record.GetString("stage") == stored.GetString("stage") {
+				return nil
+			}
+			if err := rollUpFrameStages(txApp, e.Record); err != nil {
+				return fmt.Errorf("update order status: %w", err)
+			}
+			return nil
+		})
+		e.App = originalApp
+
+		return txErr
+	})
+}
+
+// syncFrameStage works out the frame's stage and fills in the rest from it. An explicit stage
+// change wins; otherwise ticking or unticking artworkComplete/framingComplete (or filling in
+// preservationDate) moves the stage, so clients that only know the booleans keep working.
+// Stages newly reached get a timestamp and stages gone back past lose theirs.
+func syncFrameStage(frame *core.Record, stored *core.Record, now types.DateTime) {
+	storedIndex := -1
+	storedArtwork, storedFraming, storedPreserved := false, false, false
+	if stored != nil {
+		storedIndex = frameStageIndex(stored.GetString("stage"))
+		storedArtwork = stored.GetBool("artworkComplete")
+		storedFraming = stored.GetBool("framingComplete")
+		storedPreserved = !stored.GetDateTime("preservationDate").IsZero()
+	}
+
+	index := frameStageIndex(frame.GetString("stage"))
+	if stored == nil || frame.GetString("stage") == stored.GetString("stage") {
+		preserved := frameStageIndex("preserved")
+		artwork := frameStageIndex("artwork")
+		framed := frameStageIndex("framed")
+
+		if !frame.GetDateTime("preservationDate").IsZero() && !storedPreserved {
+			index = max(index, preserved)
+		}
+		if artworkComplete := frame.GetBool("artworkComplete"); artworkComplete != storedArtwork {
+			if artworkComplete {
+				index = max(index, artwork)
+			} else {
+				index = min(index, artwork-1)
+			}
+		}
+		if framingComplete := frame.GetBool("framingComplete"); framingComplete != storedFraming {
+			if framingComplete {
+				index = max(index, framed)
+			} else {
+				index = min(index, framed-1)
+			}
+		}
+	}
+
+	frame.Set("stage", frameStages[index])
+	frame.Set("artworkComplete", index >= frameStageIndex("artwork"))
+	frame.Set("framingComplete", index >= frameStageIndex("framed"))
+
+	for i, stage := range frameStages {
+		field := frameStageDateFields[stage]
+		switch {
+		case i > index:
+			frame.Set(field, "")
+		case i > storedIndex && frame.GetDateTime(field).IsZero():
+			frame.Set(field, now)
+		}
+	}
+
+	if index >= frameStageIndex("preserved") && frame.GetDateTime("preservationDate").IsZero() {
+		frame.Set("preservationDate", now)
+	}
+}
+
+// rollUpFrameStages moves the order containing frame to the status its frames add up to. The
+// allowed transitions it steps through on the way are each kept in the status history.
+func rollUpFrameStages(app core.App, frame *core.Record) error {
+	orders, err := app.FindRecordsByFilter(
+		"orders",
+		"frameOrderId ~ {:frameId}",
+		"",
+		1,
+		0,
+		dbx.Params{"frameId": frame.Id},
+	)
+	if err != nil || len(orders) == 0 {
+		return err
+	}
+	order := orders[0]
+
+	frames, err := fetchRecordsByIds(app, "order_frame_items", order.GetStringSlice("frameOrderId"))
+	if err != nil {
+		return err
+	}
+	stages := make([]string, 0, len(frames))
+	for _, f := range frames {
+		stages = append(stages, f.GetString("stage"))
+	}
+
+	current := order.GetString("orderStatus")
+	target, reason := orderStatusForFrameStages(current, stages)
+	steps := orderStatusPath(current, target)
+	if len(steps) == 0 {
+		return nil
+	}
+
+	// one save, so the status emails only go out for where the order ends up; the steps on the
+	// way there are written to the history by the order hooks
+	order.Set("orderStatus", target)
+	order.Set(orderStatusStepsKey, steps)
+	order.Set(orderStatusAuthKey, frame.Get(orderStatusAuthKey))
+	order.Set(orderStatusReasonKey, reason)
+	return app.Save(order)
+}
+
+// orderStatusForFrameStages is the status an order in current should have given its frames'
+// stages, and why. Delivered and cancelled orders are left alone.
+func orderStatusForFrameStages(current string, stages []string) (string, string) {
+	if current == "delivered" || current == "cancelled" || len(stages) == 0 {
+		return current, ""
+	}
+
+	lowest, highest := len(frameStages)-1, 0
+	for _, stage := range stages {
+		index := frameStageIndex(stage)
+		lowest = min(lowest, index)
+		highest = max(highest, index)
+	}
+
+	switch {
+	case lowest >= frameStageIndex("collected"):
+		return "delivered", "All frames collected."
+	case lowest >= frameStageIndex("quality_checked"):
+		return "ready", "All frames quality checked."
+	case current == "ready":
+		return "in_progress", fmt.Sprintf("A frame went back to %s.", frameStageLabels[frameStages[lowest]])
+	case highest >= frameStageIndex("preserved") && firstNonEmpty(current, "draft") == "draft":
+		return "in_progress", "Frame work started."
+	}
+	return current, ""
+}
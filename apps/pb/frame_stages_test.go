@@ -0,0 +1,316 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func createTestFrame(t testing.TB, app core.App, values map[string]any) *core.Record {
+	coll, err := app.FindCollectionByNameOrId("order_frame_items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := core.NewRecord(coll)
+	for key, value := range values {
+		frame.Set(key, value)
+	}
+	if err := app.Save(frame); err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func createTestOrderWithFrames(t testing.TB, app core.App, frames ...*core.Record) *core.Record {
+	coll, err := app.FindCollectionByNameOrId("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := core.NewRecord(coll)
+	ids := make([]string, 0, len(frames))
+	for _, frame := range frames {
+		ids = append(ids, frame.Id)
+	}
+	order.Set("frameOrderId", ids)
+	if err := app.Save(order); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func setFrameStage(t testing.TB, app core.App, frame *core.Record, stage string) {
+	t.Helper()
+
+	frame.Set("stage", stage)
+	if err := app.Save(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertOrderStatus(t testing.TB, app core.App, orderId, expected string) {
+	t.Helper()
+
+	order, err := app.FindRecordById("orders", orderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := order.GetString("orderStatus"); got != expected {
+		t.Errorf("expected order status %q, got %q", expected, got)
+	}
+}
+
+func TestFrameStageKeepsBooleansAndDatesInStep(t *testing.T) {
+	app := newTestApp(t, registerOrderStatusHooks, registerFrameStageHooks)
+
+	frame := createTestFrame(t, app, nil)
+	if frame.GetString("stage") != "received" || frame.GetDateTime("receivedAt").IsZero() {
+		t.Fatalf("expected a new frame to be received now, got %q at %q", frame.GetString("stage"), frame.GetString("receivedAt"))
+	}
+
+	// the order page only ticks the booleans
+	frame.Set("framingComplete", true)
+	if err := app.Save(frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.GetString("stage") != "framed" || !frame.GetBool("artworkComplete") {
+		t.Errorf("expected framingComplete to move the frame to framed with artwork done, got %q", frame.GetString("stage"))
+	}
+	for _, field := range []string{"preservedAt", "artworkAt", "mountedAt", "framedAt", "preservationDate"} {
+		if frame.GetDateTime(field).IsZero() {
+			t.Errorf("expected %s to be set", field)
+		}
+	}
+
+	frame.Set("artworkComplete", false)
+	if err := app.Save(frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.GetString("stage") != "preserved" || frame.GetBool("framingComplete") {
+		t.Errorf("expected unticking artwork to go back to preserved, got %q", frame.GetString("stage"))
+	}
+	if !frame.GetDateTime("artworkAt").IsZero() || !frame.GetDateTime("framedAt").IsZero() {
+		t.Error("expected the dates of stages gone back past to be cleared")
+	}
+
+	setFrameStage(t, app, frame, "quality_checked")
+	if !frame.GetBool("artworkComplete") || !frame.GetBool("framingComplete") {
+		t.Error("expected an explicit stage to set both booleans")
+	}
+}
+
+func TestFramingCompleteQueuesEmail(t *testing.T) {
+	app := newTestApp(t, registerOrderStatusHooks, registerFrameStageHooks, registerStatusEmailHooks)
+
+	frame := createTestFrame(t, app, nil)
+	order := createTestOrderWithFrames(t, app, frame)
+	createTestCustomer(t, app, order.Id, "jane@example.com")
+
+	frame.Set("framingComplete", true)
+	if err := app.Save(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	logRec := findOnlyEmailLog(t, app, "status_update")
+	if logRec.GetString("eventType") != "framing_complete" || logRec.GetString("frameItemId") != frame.Id {
+		t.Errorf("expected the framing complete email for the frame, got %q", logRec.GetString("eventType"))
+	}
+	assertEmailLog(t, logRec, "queued", "queued")
+}
+
+func TestFrameStagesRollUpIntoOrderStatus(t *testing.T) {
+	app := newTestApp(t, registerOrderStatusHooks, registerFrameStageHooks)
+
+	first := createTestFrame(t, app, nil)
+	second := createTestFrame(t, app, nil)
+	order := createTestOrderWithFrames(t, app, first, second)
+
+	setFrameStage(t, app, first, "preserved")
+	assertOrderStatus(t, app, order.Id, "in_progress")
+
+	setFrameStage(t, app, first, "quality_checked")
+	assertOrderStatus(t, app, order.Id, "in_progress")
+
+	setFrameStage(t, app, second, "quality_checked")
+	assertOrderStatus(t, app, order.Id, "ready")
+
+	setFrameStage(t, app, second, "framed")
+	assertOrderStatus(t, app, order.Id, "in_progress")
+
+	setFrameStage(t, app, second, "collected")
+	setFrameStage(t, app, first, "collected")
+	assertOrderStatus(t, app, order.Id, "delivered")
+
+	// the last save stepped through ready on the way, so both are in the history
+	history := findStatusHistory(t, app, order.Id)
+	statuses := []string{}
+	for _, entry := range history {
+		statuses = append(statuses, entry.GetString("toStatus"))
+	}
+	expected := []string{"draft", "in_progress", "ready", "in_progress", "ready", "delivered"}
+	if !slices.Equal(statuses, expected) {
+		t.Errorf("expected history %v, got %v", expected, statuses)
+	}
+	if reason := history[len(history)-1].GetString("reason"); reason != "All frames collected." {
+		t.Errorf("expected the roll-up reason, got %q", reason)
+	}
+}
+
+func TestFrameRollUpSendsOneStatusEmail(t *testing.T) {
+	app := newTestApp(t, registerOrderStatusHooks, registerFrameStageHooks, registerStatusEmailHooks)
+
+	frame := createTestFrame(t, app, nil)
+	order := createTestOrderWithFrames(t, app, frame)
+	createTestCustomer(t, app, order.Id, "jane@example.com")
+
+	// draft straight to ready: one save, with in_progress only in the history
+	setFrameStage(t, app, frame, "quality_checked")
+	assertOrderStatus(t, app, order.Id, "ready")
+
+	logs, err := app.FindRecordsByFilter("email_logs", "eventType = 'order_ready'", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 order_ready email, got %d", len(logs))
+	}
+	if note := logs[0].GetString("eventNote"); note != "Draft -> Ready" {
+		t.Errorf("expected the note Draft -> Ready, got %q", note)
+	}
+
+	history := findStatusHistory(t, app, order.Id)
+	expected := [][2]string{{"", "draft"}, {"draft", "in_progress"}, {"in_progress", "ready"}}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d history rows, got %d", len(expected), len(history))
+	}
+	for i, entry := range history {
+		if entry.GetString("fromStatus") != expected[i][0] || entry.GetString("toStatus") != expected[i][1] {
+			t.Errorf("row %d: expected %v, got %s -> %s", i, expected[i], entry.GetString("fromStatus"), entry.GetString("toStatus"))
+		}
+	}
+}
+
+func TestOrderStatusPath(t *testing.T) {
+	scenarios := []struct {
+		from, to string
+		expected []string
+	}{
+		{"draft", "delivered", []string{"in_progress", "ready", "delivered"}},
+		{"", "in_progress", []string{"in_progress"}},
+		{"ready", "in_progress", []string{"in_progress"}},
+		{"ready", "ready", nil},
+		{"delivered", "draft", nil},
+	}
+
+	for _, s := range scenarios {
+		if got := orderStatusPath(s.from, s.to); !slices.Equal(got, s.expected) {
+			t.Errorf("%q -> %q: expected %v, got %v", s.from, s.to, s.expected, got)
+		}
+	}
+}
+
+func TestFindWorkshopFramesSortsBySoonestOccasion(t *testing.T) {
+	app := newTestApp(t, registerOrderStatusHooks, registerFrameStageHooks)
+
+	mounted := func(mountedAt string) *core.Record {
+		return createTestFrame(t, app, map[string]any{"stage": "mounted", "mountedAt": mountedAt})
+	}
+	onOrder := func(occasionDate string, mountedAt string) *core.Record {
+		frame := mounted(mountedAt)
+		order := createTestOrderWithFrames(t, app, frame)
+		order.Set("occasionDate", occasionDate)
+		if err := app.Save(order); err != nil {
+			t.Fatal(err)
+		}
+		return frame
+	}
+	later := onOrder("2026-09-01", "2026-05-01 10:00:00.000Z")
+	loose := mounted("2026-05-03 10:00:00.000Z")
+	undated := onOrder("", "2026-05-02 10:00:00.000Z")
+	sooner := onOrder("2026-06-01", "2026-05-04 10:00:00.000Z")
+	createTestFrame(t, app, map[string]any{"stage": "framed"})
+
+	frames, err := findWorkshopFrames(app, "mounted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, frame := range frames {
+		ids = append(ids, frame.Id)
+	}
+	// the two without an occasion go last, longest in the stage first
+	expected := []string{sooner.Id, later.Id, undated.Id, loose.Id}
+	if !slices.Equal(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+}
+
+func TestWorkshopFramesApi(t *testing.T) {
+	createFrames := func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+		registerOrderStatusHooks(&pocketbase.PocketBase{App: app})
+		registerFrameStageHooks(&pocketbase.PocketBase{App: app})
+		registerWorkshopRoutes(e, &pocketbase.PocketBase{App: app})
+
+		mounted := createTestFrame(t, app, map[string]any{"stage": "mounted", "sizeX": "12", "sizeY": "16"})
+		framed := createTestFrame(t, app, map[string]any{"stage": "framed"})
+		order := createTestOrderWithFrames(t, app, framed, mounted)
+
+		customers, err := app.FindCollectionByNameOrId("customers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		customer := core.NewRecord(customers)
+		customer.Set("firstName", "Jane")
+		customer.Set("surname", "Doe")
+		customer.Set("orderId", order.Id)
+		if err := app.Save(customer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodGet,
+			URL:             "/api/workshop/frames?stage=mounted",
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  createFrames,
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"data":{}`},
+		},
+		{
+			Name:            "invalid stage",
+			Method:          http.MethodGet,
+			URL:             "/api/workshop/frames?stage=polished",
+			Headers:         userAuthHeader(t),
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  createFrames,
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`Invalid stage \"polished\"`},
+		},
+		{
+			Name:           "frames in stage",
+			Method:         http.MethodGet,
+			URL:            "/api/workshop/frames?stage=mounted",
+			Headers:        userAuthHeader(t),
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: createFrames,
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"total":1`,
+				`"label":"Item 2"`,
+				`"sizeX":"12"`,
+				`"customerName":"Jane Doe"`,
+				`"orderStatus":"draft"`,
+			},
+			NotExpectedContent: []string{`"stage":"framed"`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
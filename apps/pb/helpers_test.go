@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+// newEmailTestApp is a test app with the repo migrations applied, the in-process PDF renderer
+// and a fresh fakeMailClient (reachable through currentMailClient). It is the TestAppFactory of
+// the API scenarios, which clean the app up themselves.
+func newEmailTestApp(t testing.TB) *tests.TestApp {
+	app, err := tests.NewTestApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.RunAllMigrations(); err != nil {
+		t.Fatal(err)
+	}
+
+	setPdfRenderer(&nativePdfRenderer{})
+	setMailClient(&fakeMailClient{})
+	t.Cleanup(func() { setMailClient(nil) })
+
+	return app
+}
+
+// newTestApp is newEmailTestApp for tests that use the app directly: it is cleaned up when the
+// test ends, and registerFns (eg registerOrderStatusHooks) bind their hooks to it.
+func newTestApp(t testing.TB, registerFns ...func(app *pocketbase.PocketBase)) *tests.TestApp {
+	app := newEmailTestApp(t)
+	t.Cleanup(app.Cleanup)
+
+	for _, register := range registerFns {
+		register(&pocketbase.PocketBase{App: app})
+	}
+	return app
+}
+
+// authHeader signs in as test@example.com from the test data's users or superusers collection.
+func authHeader(t testing.TB, collection string) map[string]string {
+	app, err := tests.NewTestApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Cleanup()
+
+	record, err := app.FindAuthRecordByEmail(collection, "test@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := record.NewAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"Authorization": token}
+}
+
+func userAuthHeader(t testing.TB) map[string]string {
+	return authHeader(t, "users")
+}
+
+func superuserAuthHeader(t testing.TB) map[string]string {
+	return authHeader(t, core.CollectionNameSuperusers)
+}
+
+type fakeMail struct {
+	Message     *mailer.Message
+	Attachments map[string][]byte
+}
+
+// fakeMailClient keeps sent messages in memory so tests can assert on them. Attachment readers
+// are drained on send, so their bytes are kept alongside. Set Err to make sends fail.
+type fakeMailClient struct {
+	mu   sync.Mutex
+	Sent []fakeMail
+	Err  error
+}
+
+func (c *fakeMailClient) Send(msg *mailer.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Err != nil {
+		return c.Err
+	}
+
+	attachments := make(map[string][]byte, len(msg.Attachments))
+	for name, reader := range msg.Attachments {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		attachments[name] = data
+	}
+	c.Sent = append(c.Sent, fakeMail{Message: msg, Attachments: attachments})
+	return nil
+}
+
+func (c *fakeMailClient) Messages() []fakeMail {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]fakeMail(nil), c.Sent...)
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	maxInboundAttachments = 20
+	maxInboundMIMEDepth   = 10
+)
+
+var (
+	inboundInvoiceNoPattern    = regexp.MustCompile(`(?i)\binvoice\s*(?:no\.?|number)?\s*[#:]?\s*(\d+)`)
+	inboundCreditNoteNoPattern = regexp.MustCompile(`(?i)\b(CN-\d+)\b`)
+	inboundOrderNoPattern      = regexp.MustCompile(`(?i)(?:\border|\breference|\bref)\s*(?:no\.?|number)?\s*[#:]?\s*#?(\d+)`)
+)
+
+// inboundEmail is the provider-neutral shape of a received message.
+type inboundEmail struct {
+	From        mail.Address
+	To          []string
+	Cc          []string
+	Subject     string
+	Text        string
+	HTML        string
+	MessageId   string
+	InReplyTo   string
+	Date        time.Time
+	Attachments []inboundAttachment
+}
+
+type inboundAttachment struct {
+	Name string
+	Data []byte
+}
+
+// inboundEmailJSON is the JSON form of the webhook. Providers that forward the original MIME
+// can put it in "raw" instead of filling the other fields.
+type inboundEmailJSON struct {
+	From        string           `json:"from"`
+	To          inboundAddresses `json:"to"`
+	Cc          inboundAddresses `json:"cc"`
+	Subject     string           `json:"subject"`
+	Text        string           `json:"text"`
+	HTML        string           `json:"html"`
+	MessageId   string           `json:"messageId"`
+	InReplyTo   string           `json:"inReplyTo"`
+	Raw         string           `json:"raw"`
+	Attachments []struct {
+		Filename string `json:"filename"`
+		Content  string `json:"content"` // base64
+	} `json:"attachments"`
+}
+
+// inboundAddresses accepts either "a@x, b@y" or ["a@x", "b@y"].
+type inboundAddresses []string
+
+func (a *inboundAddresses) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*a = list
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(b, &single); err != nil {
+		return err
+	}
+	*a = strings.Split(single, ",")
+	return nil
+}
+
+// headerGetter is satisfied by both mail.Header and textproto.MIMEHeader.
+type headerGetter interface {
+	Get(key string) string
+}
+
+// inboundWebhookAuthorized checks the shared secret from INBOUND_EMAIL_SECRET, sent by the
+// provider as the X-Webhook-Secret header or a ?token= query param.
+func inboundWebhookAuthorized(e *core.RequestEvent) bool {
+	secret := strings.TrimSpace(os.Getenv("INBOUND_EMAIL_SECRET"))
+	if secret == "" {
+		return false
+	}
+	given := firstNonEmpty(e.Request.Header.Get("X-Webhook-Secret"), e.Request.URL.Query().Get("token"))
+	return subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+func handleInboundEmail(app core.App, e *core.RequestEvent) error {
+	if !inboundWebhookAuthorized(e) {
+		return e.JSON(http.StatusUnauthorized, map[string]any{
+			"ok":    false,
+			"error": "Invalid webhook secret.",
+		})
+	}
+
+	raw, err := io.ReadAll(e.Request.Body)
+	if err == nil && len(bytes.TrimSpace(raw)) == 0 {
+		err = errors.New("empty body")
+	}
+	var in *inboundEmail
+	if err == nil {
+		in, err = parseInboundRequest(e.Request.Header.Get("Content-Type"), raw)
+	}
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":      false,
+			"error":   "Invalid inbound email.",
+			"details": err.Error(),
+		})
+	}
+
+	if in.MessageId != "" {
+		existing, err := app.FindFirstRecordByData("communications", "messageId", in.MessageId)
+		if err == nil {
+			return e.JSON(http.StatusOK, map[string]any{"ok": true, "duplicate": true, "communicationId": existing.Id})
+		}
+	}
+
+	rec, err := storeInboundEmail(app, in)
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to store inbound email.",
+			"details": err.Error(),
+		})
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{
+		"ok":              true,
+		"communicationId": rec.Id,
+		"orderId":         rec.GetString("orderId"),
+		"matchedBy":       rec.GetString("matchedBy"),
+	})
+}
+
+// parseInboundRequest reads the JSON form for application/json and treats anything else as
+// the raw RFC 822 message.
+func parseInboundRequest(contentType string, raw []byte) (*inboundEmail, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" {
+		return parseInboundMIME(raw)
+	}
+
+	var payload inboundEmailJSON
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("json decode failed: %w", err)
+	}
+	if strings.TrimSpace(payload.Raw) != "" {
+		return parseInboundMIME([]byte(payload.Raw))
+	}
+
+	in := &inboundEmail{
+		Subject:   strings.TrimSpace(payload.Subject),
+		Text:      payload.Text,
+		HTML:      payload.HTML,
+		MessageId: trimMessageId(payload.MessageId),
+		InReplyTo: trimMessageId(payload.InReplyTo),
+		Date:      time.Now(),
+	}
+	from, err := mail.ParseAddress(payload.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %q", payload.From)
+	}
+	in.From = *from
+	in.To = normalizeInboundAddresses(payload.To)
+	in.Cc = normalizeInboundAddresses(payload.Cc)
+
+	for _, att := range payload.Attachments {
+		data, err := base64.StdEncoding.DecodeString(att.Content)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %q is not base64", att.Filename)
+		}
+		in.addAttachment(att.Filename, data)
+	}
+
+	return in, nil
+}
+
+func normalizeInboundAddresses(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if addr, err := mail.ParseAddress(strings.TrimSpace(value)); err == nil {
+			result = append(result, strings.ToLower(addr.Address))
+		}
+	}
+	return result
+}
+
+func trimMessageId(value string) string {
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
+
+func (in *inboundEmail) addAttachment(name string, data []byte) {
+	if len(in.Attachments) >= maxInboundAttachments {
+		return
+	}
+	name = firstNonEmpty(strings.TrimSpace(name), fmt.Sprintf("attachment-%d", len(in.Attachments)+1))
+	in.Attachments = append(in.Attachments, inboundAttachment{Name: name, Data: data})
+}
+
+// parseInboundMIME pulls the addresses, text/html bodies and attachments out of a raw message.
+func parseInboundMIME(raw []byte) (*inboundEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %w", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	in := &inboundEmail{
+		From:      *from,
+		Subject:   strings.TrimSpace(subject),
+		MessageId: trimMessageId(msg.Header.Get("Message-Id")),
+		InReplyTo: trimMessageId(msg.Header.Get("In-Reply-To")),
+		Date:      time.Now(),
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		in.Date = date
+	}
+
+	// forwarding setups often rewrite To, so the envelope headers are checked for the reply tag too
+	for _, key := range []string{"To", "Delivered-To", "X-Original-To"} {
+		if list, err := msg.Header.AddressList(key); err == nil {
+			for _, addr := range list {
+				in.To = append(in.To, strings.ToLower(addr.Address))
+			}
+		}
+	}
+	if list, err := msg.Header.AddressList("Cc"); err == nil {
+		for _, addr := range list {
+			in.Cc = append(in.Cc, strings.ToLower(addr.Address))
+		}
+	}
+
+	if err := in.readPart(msg.Header, msg.Body, 0); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (in *inboundEmail) readPart(header headerGetter, body io.Reader, depth int) error {
+	if depth > maxInboundMIMEDepth {
+		return errors.New("message nested too deeply")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read %s: %w", mediaType, err)
+			}
+			if err := in.readPart(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	// multipart.Reader already undoes quoted-printable, but not base64
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", mediaType, err)
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := firstNonEmpty(dispositionParams["filename"], params["name"])
+	if disposition == "attachment" || filename != "" {
+		in.addAttachment(filename, data)
+		return nil
+	}
+
+	switch mediaType {
+	case "text/plain":
+		if in.Text == "" {
+			in.Text = string(data)
+		}
+	case "text/html":
+		if in.HTML == "" {
+			in.HTML = string(data)
+		}
+	}
+	return nil
+}
+
+// resolveReplyAddress splits EMAIL_REPLY_ADDRESS ("replies@inbound.example.com") into the
+// parts used to build and recognise tagged addresses like replies+<orderId>@inbound.example.com.
+func resolveReplyAddress() (string, string, bool) {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv("EMAIL_REPLY_ADDRESS")))
+	local, domain, ok := strings.Cut(raw, "@")
+	if !ok || local == "" || domain == "" {
+		return "", "", false
+	}
+	return local, domain, true
+}
+
+// tagReplyTo points customer replies at the order's tagged reply address. A Reply-To chosen by
+// staff (see email_options.go) is left alone.
+func tagReplyTo(msg *mailer.Message, orderId string) {
+	local, domain, ok := resolveReplyAddress()
+	if !ok || strings.TrimSpace(orderId) == "" {
+		return
+	}
+	if msg.Headers["Reply-To"] != "" {
+		return
+	}
+	if msg.Headers == nil {
+		msg.Headers = map[string]string{}
+	}
+	msg.Headers["Reply-To"] = fmt.Sprintf("%s+%s@%s", local, orderId, domain)
+}
+
+// replyTagOrderId returns the order id tagged onto one of our reply addresses, if any.
+func replyTagOrderId(addresses []string) string {
+	local, domain, ok := resolveReplyAddress()
+	if !ok {
+		return ""
+	}
+	for _, address := range addresses {
+		addrLocal, addrDomain, _ := strings.Cut(address, "@")
+		if addrDomain != domain {
+			continue
+		}
+		if tag, found := strings.CutPrefix(addrLocal, local+"+"); found && tag != "" {
+			return tag
+		}
+	}
+	return ""
+}
+
+// matchInboundOrder finds the order a message is about: the tagged reply address first, then an
+// invoice, credit note, order reference or order number in the subject.
+func matchInboundOrder(app core.App, in *inboundEmail) (*core.Record, string) {
+	if orderId := replyTagOrderId(append(append([]string{}, in.To...), in.Cc...)); orderId != "" {
+		if order, err := app.FindRecordById("orders", orderId); err == nil {
+			return order, "reply_tag"
+		}
+	}
+
+	invoiceNo := ""
+	if m := inboundCreditNoteNoPattern.FindStringSubmatch(in.Subject); m != nil {
+		invoiceNo = strings.ToUpper(m[1])
+	} else if m := sequenceFormatPattern(resolveInvoiceNumbering().Format).FindStringSubmatch(in.Subject); m != nil {
+		invoiceNo = m[1]
+	} else if m := inboundInvoiceNoPattern.FindStringSubmatch(in.Subject); m != nil {
+		// invoices issued before the invoice sequence carry the bare order number
+		invoiceNo = m[1]
+	}
+	if invoiceNo != "" {
+		invoices, err := app.FindRecordsByFilter("invoices", "invoiceNo = {:no}", "-created", 1, 0, dbx.Params{"no": invoiceNo})
+		if err == nil && len(invoices) > 0 {
+			if order, err := app.FindRecordById("orders", invoices[0].GetString("orderId")); err == nil {
+				return order, "invoice_no"
+			}
+		}
+	}
+
+	if format := resolveOrderRefFormat(); format != "" {
+		if m := sequenceFormatPattern(format).FindStringSubmatch(in.Subject); m != nil {
+			orders, err := app.FindRecordsByFilter("orders", "orderRef = {:ref}", "", 1, 0, dbx.Params{"ref": m[1]})
+			if err == nil && len(orders) > 0 {
+				return orders[0], "order_no"
+			}
+		}
+	}
+
+	// older invoice numbers were the order number, so an unknown invoice number may still be an order
+	orderNo := invoiceNo
+	if m := inboundOrderNoPattern.FindStringSubmatch(in.Subject); m != nil {
+		orderNo = m[1]
+	}
+	if no, err := strconv.Atoi(orderNo); err == nil {
+		orders, err := app.FindRecordsByFilter("orders", "orderNo = {:no}", "-created", 1, 0, dbx.Params{"no": no})
+		if err == nil && len(orders) > 0 {
+			return orders[0], "order_no"
+		}
+	}
+
+	return nil, "unmatched"
+}
+
+// storeInboundEmail writes the message to communications, linked to the matched order and its
+// customer. Unmatched mail is still stored (by sender where possible) so nothing is lost.
+func storeInboundEmail(app core.App, in *inboundEmail) (*core.Record, error) {
+	coll, err := app.FindCollectionByNameOrId("communications")
+	if err != nil {
+		return nil, err
+	}
+
+	rec := core.NewRecord(coll)
+	rec.Set("channel", "email")
+	rec.Set("direction", "inbound")
+	rec.Set("fromAddress", strings.ToLower(in.From.Address))
+	rec.Set("fromName", in.From.Name)
+	rec.Set("to", in.To)
+	rec.Set("cc", in.Cc)
+	rec.Set("subject", in.Subject)
+	rec.Set("text", in.Text)
+	rec.Set("html", in.HTML)
+	rec.Set("messageId", in.MessageId)
+	rec.Set("inReplyTo", in.InReplyTo)
+	receivedAt, _ := types.ParseDateTime(in.Date)
+	rec.Set("receivedAt", receivedAt)
+
+	order, matchedBy := matchInboundOrder(app, in)
+	if order != nil {
+		rec.Set("orderId", order.Id)
+		customers, err := fetchRecordsByField(app, "customers", "orderId", []string{order.Id})
+		if err == nil && len(customers) > 0 {
+			rec.Set("customerId", customers[0].Id)
+		}
+	} else {
+		// addresses are stored as typed by staff, so compare case-insensitively
+		customer := &core.Record{}
+		err := app.RecordQuery("customers").
+			AndWhere(dbx.NewExp("LOWER([[email]]) = {:email}", dbx.Params{"email": strings.ToLower(in.From.Address)})).
+			OrderBy("created DESC").
+			Limit(1).
+			One(customer)
+		if err == nil {
+			rec.Set("customerId", customer.Id)
+			matchedBy = "sender"
+		}
+	}
+	rec.Set("matchedBy", matchedBy)
+
+	files := make([]*filesystem.File, 0, len(in.Attachments))
+	originalNames := make(map[string]string, len(in.Attachments))
+	for _, att := range in.Attachments {
+		file, err := filesystem.NewFileFromBytes(att.Data, att.Name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		originalNames[file.Name] = att.Name
+	}
+	if len(files) > 0 {
+		rec.Set("attachments", files)
+	}
+	rec.Set("attachmentNames", originalNames)
+
+	if err := app.Save(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
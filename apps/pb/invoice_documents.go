@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// invoiceDocument is what gets emailed: either a freshly issued invoice or a stored one being re-sent.
+type invoiceDocument struct {
+	Record  *core.Record // nil when the payload isn't tied to an order, so nothing was persisted
+	Invoice invoiceViewModel
+	HTML    string
+	PDF     []byte
+	Reused  bool
+}
+
+// invoiceStageError carries the email_logs stage the failure belongs to.
+type invoiceStageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *invoiceStageError) Error() string { return e.Err.Error() }
+func (e *invoiceStageError) Unwrap() error { return e.Err }
+
+func findLatestIssuedInvoice(app core.App, orderId string) (*core.Record, error) {
+	records, err := app.FindRecordsByFilter(
+		"invoices",
+		`orderId = {:orderId} && kind = "invoice" && status = "issued"`,
+		"-version",
+		1,
+		0,
+		dbx.Params{"orderId": orderId},
+	)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+func readInvoicePdf(app core.App, rec *core.Record) ([]byte, error) {
+	filename := rec.GetString("pdf")
+	if filename == "" {
+		return nil, fmt.Errorf("invoice %s has no stored pdf", rec.Id)
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	reader, err := fsys.GetReader(rec.BaseFilesPath() + "/" + filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// resolveInvoiceDocument re-uses the latest stored invoice for the order unless a new version
+// is explicitly requested (or none has been issued yet), in which case it renders and stores one.
+func resolveInvoiceDocument(
+	app core.App,
+	e *core.RequestEvent,
+	payload invoicePayload,
+	previewTemplatePath string,
+	issueNewVersion bool,
+) (*invoiceDocument, error) {
+	orderId := invoicePayloadOrderId(payload)
+
+	if !issueNewVersion {
+		if doc, err := findReusableInvoiceDocument(app, orderId); err != nil || doc != nil {
+			return doc, err
+		}
+	}
+
+	if orderId == "" {
+		// nothing is stored without an order, so no invoice number is used up either
+		return renderInvoiceDocument(app, payload, previewTemplatePath, "-")
+	}
+
+	return issueInvoiceDocument(app, e, orderId, payload, previewTemplatePath)
+}
+
+// issueInvoiceDocument allocates the invoice number, renders and stores the invoice in one
+// transaction, so a number is only used up by an invoice that was actually saved.
+func issueInvoiceDocument(
+	app core.App,
+	e *core.RequestEvent,
+	orderId string,
+	payload invoicePayload,
+	previewTemplatePath string,
+) (*invoiceDocument, error) {
+	var doc *invoiceDocument
+
+	err := app.RunInTransaction(func(txApp core.App) error {
+		sequence, invoiceNo, err := nextInvoiceNo(txApp, time.Now())
+		if err != nil {
+			return &invoiceStageError{Stage: "store_invoice", Err: err}
+		}
+
+		doc, err = renderInvoiceDocument(txApp, payload, previewTemplatePath, invoiceNo)
+		if err != nil {
+			return err
+		}
+
+		rec, err := storeInvoiceDocument(txApp, e, orderId, payload, doc, sequence)
+		if err != nil {
+			return &invoiceStageError{Stage: "store_invoice", Err: err}
+		}
+		doc.Record = rec
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// findReusableInvoiceDocument loads the latest issued invoice for the order, or nil when there is none.
+func findReusableInvoiceDocument(app core.App, orderId string) (*invoiceDocument, error) {
+	if orderId == "" {
+		return nil, nil
+	}
+
+	// a failed lookup must not pass for "none issued": the caller would issue a new version and
+	// use up an invoice number
+	latest, err := findLatestIssuedInvoice(app, orderId)
+	if err != nil {
+		return nil, &invoiceStageError{Stage: "load_invoice", Err: err}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+
+	pdfBytes, err := readInvoicePdf(app, latest)
+	if err != nil {
+		return nil, &invoiceStageError{Stage: "load_invoice", Err: err}
+	}
+
+	var view invoiceViewModel
+	_ = latest.UnmarshalJSONField("snapshot", &view)
+
+	return &invoiceDocument{
+		Record:  latest,
+		Invoice: view,
+		HTML:    latest.GetString("html"),
+		PDF:     pdfBytes,
+		Reused:  true,
+	}, nil
+}
+
+// sameInvoiceFigures reports whether two invoices show the same totals, payments and balance.
+func sameInvoiceFigures(a, b invoiceViewModel) bool {
+	return a.SubTotal == b.SubTotal &&
+		a.VatTotal == b.VatTotal &&
+		a.GrandTotal == b.GrandTotal &&
+		a.Credits == b.Credits &&
+		a.BalanceDue == b.BalanceDue
+}
+
+// findCurrentIssuedInvoice is findReusableInvoiceDocument, but only while the stored figures
+// still match current (the order as it is now). It is nil once a payment or price change has
+// moved them.
+func findCurrentIssuedInvoice(app core.App, orderId string, current invoiceViewModel) (*invoiceDocument, error) {
+	issued, err := findReusableInvoiceDocument(app, orderId)
+	if err != nil || issued == nil {
+		return nil, err
+	}
+	if !sameInvoiceFigures(issued.Invoice, current) {
+		return nil, nil
+	}
+	return issued, nil
+}
+
+// renderInvoiceDocument renders the invoice HTML and PDF from the payload without storing anything.
+func renderInvoiceDocument(app core.App, payload invoicePayload, previewTemplatePath string, invoiceNo string) (*invoiceDocument, error) {
+	payments, err := loadInvoicePayments(app, invoicePayloadOrderId(payload))
+	if err != nil {
+		return nil, &invoiceStageError{Stage: "load_payments", Err: err}
+	}
+
+	view := buildInvoiceViewModel(payload, payments)
+	view.InvoiceNo = invoiceNo
+
+	html, err := renderInvoiceTemplate(previewTemplatePath, view)
+	if err != nil {
+		return nil, &invoiceStageError{Stage: "render_html", Err: err}
+	}
+
+	pdfBytes, err := renderInvoicePdf(html, view)
+	if err != nil {
+		return nil, &invoiceStageError{Stage: "render_pdf", Err: err}
+	}
+
+	return &invoiceDocument{Invoice: view, HTML: html, PDF: pdfBytes}, nil
+}
+
+func invoicePayloadOrderId(payload invoicePayload) string {
+	if payload.EmailContext != nil && strings.TrimSpace(payload.EmailContext.OrderId) != "" {
+		return strings.TrimSpace(payload.EmailContext.OrderId)
+	}
+	return strings.TrimSpace(payload.Order.OrderID)
+}
+
+// storeInvoiceDocument writes a new version and supersedes the previous one in a single transaction.
+func storeInvoiceDocument(
+	app core.App,
+	e *core.RequestEvent,
+	orderId string,
+	payload invoicePayload,
+	doc *invoiceDocument,
+	sequence int,
+) (*core.Record, error) {
+	collection, err := app.FindCollectionByNameOrId("invoices")
+	if err != nil {
+		return nil, err
+	}
+
+	totals := computeInvoiceTotals(payload)
+	rec := core.NewRecord(collection)
+
+	err = app.RunInTransaction(func(txApp core.App) error {
+		previous, err := txApp.FindRecordsByFilter(
+			"invoices",
+			`orderId = {:orderId} && kind = "invoice"`,
+			"-version",
+			0,
+			0,
+			dbx.Params{"orderId": orderId},
+		)
+		if err != nil {
+			return err
+		}
+
+		version := 1
+		if len(previous) > 0 {
+			version = previous[0].GetInt("version") + 1
+		}
+
+		for _, prev := range previous {
+			if prev.GetString("status") != "issued" {
+				continue
+			}
+			prev.Set("status", "superseded")
+			if err := txApp.Save(prev); err != nil {
+				return err
+			}
+		}
+
+		pdfFile, err := filesystem.NewFileFromBytes(doc.PDF, fmt.Sprintf("invoice-%s-v%d.pdf", doc.Invoice.InvoiceNo, version))
+		if err != nil {
+			return err
+		}
+
+		rec.Set("kind", "invoice")
+		rec.Set("orderId", orderId)
+		if customerId := strings.TrimSpace(payload.Customer.ID); customerId != "" {
+			rec.Set("customerId", customerId)
+		}
+		if e != nil && e.Auth != nil && e.Auth.Collection().Name == "users" {
+			rec.Set("issuedBy", e.Auth.Id)
+		}
+		rec.Set("invoiceNo", doc.Invoice.InvoiceNo)
+		rec.Set("sequence", sequence)
+		rec.Set("version", version)
+		rec.Set("status", "issued")
+		rec.Set("issueDate", time.Now())
+		rec.Set("vatRate", totals.VatRate)
+		rec.Set("subTotal", totals.SubTotal)
+		rec.Set("vatTotal", totals.VatTotal)
+		rec.Set("grandTotal", totals.GrandTotal)
+		rec.Set("rows", doc.Invoice.Rows)
+		rec.Set("snapshot", doc.Invoice)
+		rec.Set("html", doc.HTML)
+		rec.Set("pdf", pdfFile)
+
+		return txApp.Save(rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// linkInvoiceEmailLog records which email carried the invoice. Never blocks main flow.
+func linkInvoiceEmailLog(app core.App, invoice *core.Record, logRec *core.Record) {
+	if invoice == nil || logRec == nil {
+		return
+	}
+	invoice.Set("emailLogId+", logRec.Id)
+	if err := app.Save(invoice); err != nil {
+		fmt.Println("invoice email log link failed:", err.Error())
+	}
+}
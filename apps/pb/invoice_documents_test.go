@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFindReusableInvoiceDocument(t *testing.T) {
+	app := newTestApp(t)
+
+	if doc, err := findReusableInvoiceDocument(app, testOrderId); err != nil || doc != nil {
+		t.Fatalf("expected nothing before an invoice is issued, got %v (%v)", doc, err)
+	}
+
+	issued := issueTestInvoice(t, app)
+	doc, err := findReusableInvoiceDocument(app, testOrderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc == nil || doc.Record.Id != issued.Record.Id || !doc.Reused {
+		t.Fatalf("expected the issued invoice back, got %v", doc)
+	}
+
+	// a broken lookup is an error, not "none issued", so resolving doesn't issue a new version
+	if _, err := app.DB().NewQuery("DROP TABLE {{invoices}}").Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var stageErr *invoiceStageError
+	if _, err := findReusableInvoiceDocument(app, testOrderId); !errors.As(err, &stageErr) || stageErr.Stage != "load_invoice" {
+		t.Errorf("expected a load_invoice error, got %v", err)
+	}
+}
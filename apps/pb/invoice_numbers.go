@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	invoiceNoSequence      = "invoices.invoiceNo"
+	defaultInvoiceNoFormat = "INV-{SEQ:4}"
+)
+
+// invoiceNumbering is how invoice numbers are allocated and printed.
+type invoiceNumbering struct {
+	Format string
+	Yearly bool // restart at 1 every January
+}
+
+// resolveInvoiceNumbering reads INVOICE_NO_FORMAT (default INV-{SEQ:4}, same tokens as
+// ORDER_REF_FORMAT) and INVOICE_NO_RESET=yearly. Yearly numbers repeat, so a format without
+// the year gets "{YYYY}-" in front.
+func resolveInvoiceNumbering() invoiceNumbering {
+	numbering := invoiceNumbering{
+		Format: firstNonEmpty(strings.TrimSpace(os.Getenv("INVOICE_NO_FORMAT")), defaultInvoiceNoFormat),
+		Yearly: strings.EqualFold(strings.TrimSpace(os.Getenv("INVOICE_NO_RESET")), "yearly"),
+	}
+	if !strings.Contains(numbering.Format, "{SEQ") {
+		numbering.Format += "{SEQ}"
+	}
+	if numbering.Yearly && !strings.Contains(numbering.Format, "{YY") {
+		numbering.Format = "{YYYY}-" + numbering.Format
+	}
+	return numbering
+}
+
+func (n invoiceNumbering) sequenceName(at time.Time) string {
+	if n.Yearly {
+		return invoiceNoSequence + "." + at.Format("2006")
+	}
+	return invoiceNoSequence
+}
+
+// nextInvoiceNo allocates the next invoice number. Call it in the transaction that stores the
+// invoice, so an issue that fails doesn't leave a gap.
+func nextInvoiceNo(app core.App, at time.Time) (int, string, error) {
+	numbering := resolveInvoiceNumbering()
+
+	seq, err := nextSequenceValue(app, numbering.sequenceName(at), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	return seq, formatSequenceNumber(numbering.Format, seq, at), nil
+}
+
+// peekInvoiceNo is the number the next invoice would get, for previews. It isn't reserved.
+func peekInvoiceNo(app core.App, at time.Time) string {
+	numbering := resolveInvoiceNumbering()
+
+	rec, err := findSequence(app, numbering.sequenceName(at), nil)
+	if err != nil {
+		return "-"
+	}
+	return formatSequenceNumber(numbering.Format, rec.GetInt("value")+1, at)
+}
+
+// sequenceFormatPattern matches numbers printed with format, eg for finding them in a subject.
+func sequenceFormatPattern(format string) *regexp.Regexp {
+	var pattern strings.Builder
+	last := 0
+	for _, loc := range sequenceFormatToken.FindAllStringSubmatchIndex(format, -1) {
+		pattern.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		switch format[loc[2]:loc[3]] {
+		case "YYYY":
+			pattern.WriteString(`\d{4}`)
+		case "YY", "MM":
+			pattern.WriteString(`\d{2}`)
+		default:
+			pattern.WriteString(`\d+`)
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]))
+
+	return regexp.MustCompile(`(?i)(?:^|[^\w])(` + pattern.String() + `)(?:$|[^\w])`)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestNextInvoiceNo(t *testing.T) {
+	app := newTestApp(t)
+
+	at := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+
+	for _, expected := range []string{"INV-0001", "INV-0002"} {
+		_, invoiceNo, err := nextInvoiceNo(app, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if invoiceNo != expected {
+			t.Errorf("expected %q, got %q", expected, invoiceNo)
+		}
+	}
+
+	t.Setenv("INVOICE_NO_RESET", "yearly")
+
+	// the year is added to formats without one, and each year starts again at 1
+	for _, s := range []struct {
+		at       time.Time
+		expected string
+	}{
+		{at, "2026-INV-0001"},
+		{at, "2026-INV-0002"},
+		{at.AddDate(1, 0, 0), "2027-INV-0001"},
+	} {
+		_, invoiceNo, err := nextInvoiceNo(app, s.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if invoiceNo != s.expected {
+			t.Errorf("expected %q, got %q", s.expected, invoiceNo)
+		}
+	}
+
+	t.Setenv("INVOICE_NO_FORMAT", "PP/{YY}/{SEQ:3}")
+	if no := peekInvoiceNo(app, at); no != "PP/26/003" {
+		t.Errorf("expected PP/26/003 to be next, got %q", no)
+	}
+}
+
+func TestSequenceFormatPattern(t *testing.T) {
+	scenarios := []struct {
+		format   string
+		subject  string
+		expected string
+	}{
+		{"INV-{SEQ:4}", "Re: Invoice INV-0042", "INV-0042"},
+		{"INV-{SEQ:4}", "Re: your invoice inv-0042, thanks", "inv-0042"},
+		{"INV-{SEQ:4}", "Re: XINV-0042", ""},
+		{"PP-{YYYY}-{SEQ}", "Question about PP-2026-7", "PP-2026-7"},
+		{"PP-{YYYY}-{SEQ}", "Question about PP-26-7", ""},
+		{"{YYYY}/{SEQ}", "Invoice 2026/15 (order 12)", "2026/15"},
+	}
+
+	for _, s := range scenarios {
+		got := ""
+		if m := sequenceFormatPattern(s.format).FindStringSubmatch(s.subject); m != nil {
+			got = m[1]
+		}
+		if got != s.expected {
+			t.Errorf("%s in %q: expected %q, got %q", s.format, s.subject, s.expected, got)
+		}
+	}
+}
+
+func TestInvoiceNumberMigrationCountsEmailedInvoices(t *testing.T) {
+	app := newTestApp(t)
+
+	var migration *core.Migration
+	for _, item := range core.AppMigrations.Items() {
+		if item.File == "1792238400_invoice_number_sequence.go" {
+			migration = item
+		}
+	}
+	if migration == nil {
+		t.Fatal("invoice number migration not registered")
+	}
+	if err := migration.Down(app); err != nil {
+		t.Fatal(err)
+	}
+
+	// what a baseline install has: invoices that were only ever emailed, with the orderNo as number
+	emailInvoice := func(orderNo int, status string) {
+		t.Helper()
+		order := createTestOrderWithFrames(t, app)
+		order.Set("orderNo", orderNo)
+		if err := app.Save(order); err != nil {
+			t.Fatal(err)
+		}
+		logRec, err := createEmailLog(app, nil, "jane@example.com", "Jane", "Invoice", emailLogContext{EmailType: "invoice", OrderId: order.Id}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updateEmailLog(app, logRec, status, "", nil)
+	}
+	emailInvoice(12, "sent")
+	emailInvoice(10, "sent")
+	emailInvoice(11, "failed")
+
+	if err := migration.Up(app); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Now()
+	if _, invoiceNo, err := nextInvoiceNo(app, at); err != nil || invoiceNo != "INV-0003" {
+		t.Errorf("expected the sequence to carry on after the 2 emailed invoices, got %q (%v)", invoiceNo, err)
+	}
+
+	t.Setenv("INVOICE_NO_RESET", "yearly")
+	if no := peekInvoiceNo(app, at); no != at.Format("2006")+"-INV-0003" {
+		t.Errorf("expected this year's counter to be seeded too, got %q", no)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+const invoicePdfCacheSize = 64
+
+// invoicePdfCache keeps recently rendered PDFs keyed by a hash of their input, so
+// repeated downloads of an unchanged invoice don't respawn the renderer.
+type invoicePdfCache struct {
+	mu    sync.Mutex
+	items map[string][]byte
+	order []string // oldest first, for eviction
+}
+
+var sharedInvoicePdfCache = &invoicePdfCache{items: map[string][]byte{}}
+
+// invoicePdfCacheKey hashes the rendered HTML together with the renderer name,
+// since different backends produce different bytes for the same invoice.
+func invoicePdfCacheKey(rendererName string, html string) string {
+	sum := sha256.Sum256([]byte(rendererName + "\x00" + html))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *invoicePdfCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.items[key]
+	return data, ok
+}
+
+func (c *invoicePdfCache) put(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.items[key]; ok {
+		return
+	}
+
+	c.items[key] = data
+	c.order = append(c.order, key)
+
+	for len(c.order) > invoicePdfCacheSize {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.items, oldest)
+	}
+}
+
+// renderInvoicePdfCached returns the PDF and its content hash, rendering only on a cache miss.
+func renderInvoicePdfCached(html string, view invoiceViewModel) ([]byte, string, error) {
+	key := invoicePdfCacheKey(currentPdfRenderer().Name(), html)
+	if data, ok := sharedInvoicePdfCache.get(key); ok {
+		return data, key, nil
+	}
+
+	data, err := renderInvoicePdf(html, view)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sharedInvoicePdfCache.put(key, data)
+	return data, key, nil
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/apis"
@@ -20,7 +25,23 @@ func registerInvoiceRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, prev
 			})
 		}
 
-		view := buildInvoiceViewModel(payload)
+		// preview still renders on mismatch (server totals win) but flags it for the FE
+		if mismatches := compareClientTotals(payload, computeInvoiceTotals(payload)); len(mismatches) > 0 {
+			e.Response.Header().Set("X-Invoice-Totals-Mismatch", strings.Join(mismatches, "; "))
+		}
+
+		// payments always come from the ledger, never from the FE
+		payments, err := loadInvoicePayments(app, invoicePayloadOrderId(payload))
+		if err != nil {
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to load payments.",
+				"details": err.Error(),
+			})
+		}
+
+		// unissued content, so it has no invoice number ("-") even when the order has an invoice
+		view := buildInvoiceViewModel(payload, payments)
 
 		html, err := renderInvoiceTemplate(previewTemplatePath, view)
 		if err != nil {
@@ -36,4 +57,174 @@ func registerInvoiceRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, prev
 
 		return e.HTML(http.StatusOK, html)
 	}).Bind(apis.RequireAuth())
+
+	// the order's issued invoice as it was sent while its figures still hold, otherwise the
+	// current figures (before one is issued, or once a payment has moved the balance)
+	se.Router.GET("/api/orders/{id}/invoice", func(e *core.RequestEvent) error {
+		src, err := loadOrderInvoiceSource(app, e.Request.PathValue("id"))
+		if err != nil {
+			return orderInvoiceSourceError(e, err)
+		}
+
+		view, err := buildOrderInvoiceView(app, src)
+		if err != nil {
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to load payments.",
+				"details": err.Error(),
+			})
+		}
+
+		issued, err := findCurrentIssuedInvoice(app, src.Order.Id, view)
+		if err != nil {
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to load invoice.",
+				"details": err.Error(),
+			})
+		}
+		if issued != nil {
+			return e.HTML(http.StatusOK, issued.HTML)
+		}
+
+		html, err := renderInvoiceTemplate(previewTemplatePath, view)
+		if err != nil {
+			fmt.Println("invoice render error:", err.Error())
+
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to render invoice.",
+				"details": err.Error(),
+			})
+		}
+
+		return e.HTML(http.StatusOK, html)
+	}).Bind(apis.RequireAuth())
+
+	// ?inline=1 opens the PDF in the browser (printing) instead of downloading it. Like the HTML
+	// route, it serves the stored PDF while the issued invoice's figures still hold.
+	se.Router.GET("/api/orders/{id}/invoice.pdf", func(e *core.RequestEvent) error {
+		src, err := loadOrderInvoiceSource(app, e.Request.PathValue("id"))
+		if err != nil {
+			return orderInvoiceSourceError(e, err)
+		}
+
+		view, err := buildOrderInvoiceView(app, src)
+		if err != nil {
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to load payments.",
+				"details": err.Error(),
+			})
+		}
+
+		issued, err := findCurrentIssuedInvoice(app, src.Order.Id, view)
+		if err != nil {
+			return e.JSON(http.StatusInternalServerError, map[string]any{
+				"ok":      false,
+				"error":   "Failed to load invoice.",
+				"details": err.Error(),
+			})
+		}
+
+		var pdfBytes []byte
+		var hash string
+		invoiceNo := "-"
+		if issued != nil {
+			pdfBytes = issued.PDF
+			sum := sha256.Sum256(pdfBytes)
+			hash = hex.EncodeToString(sum[:])
+			invoiceNo = firstNonEmpty(issued.Record.GetString("invoiceNo"), "-")
+		} else {
+			html, err := renderInvoiceTemplate(previewTemplatePath, view)
+			if err != nil {
+				return e.JSON(http.StatusInternalServerError, map[string]any{
+					"ok":      false,
+					"error":   "Failed to render invoice.",
+					"details": err.Error(),
+				})
+			}
+
+			pdfBytes, hash, err = renderInvoicePdfCached(html, view)
+			if err != nil {
+				return e.JSON(http.StatusInternalServerError, map[string]any{
+					"ok":      false,
+					"error":   "Failed to generate invoice PDF.",
+					"details": err.Error(),
+				})
+			}
+		}
+
+		etag := `"` + hash + `"`
+		if e.Request.Header.Get("If-None-Match") == etag {
+			e.Response.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		disposition := "attachment"
+		if e.Request.URL.Query().Get("inline") == "1" {
+			disposition = "inline"
+		}
+		filename := "invoice.pdf"
+		if invoiceNo != "-" {
+			filename = fmt.Sprintf("invoice-%s.pdf", invoiceNo)
+		}
+
+		e.Response.Header().Set("Content-Type", "application/pdf")
+		e.Response.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf("%s; filename=%q", disposition, filename),
+		)
+		e.Response.Header().Set("Content-Length", strconv.Itoa(len(pdfBytes)))
+		e.Response.Header().Set("ETag", etag)
+		e.Response.Header().Set("Cache-Control", "private, no-cache")
+		e.Response.WriteHeader(http.StatusOK)
+		_, _ = e.Response.Write(pdfBytes)
+
+		return nil
+	}).Bind(apis.RequireAuth())
+
+	se.Router.POST("/api/invoices/{id}/credit-notes", func(e *core.RequestEvent) error {
+		original, err := app.FindRecordById("invoices", e.Request.PathValue("id"))
+		if err != nil {
+			return e.JSON(http.StatusNotFound, map[string]any{
+				"ok":    false,
+				"error": "Invoice not found.",
+			})
+		}
+
+		var req creditNoteRequest
+		if err := bindPayload(e, &req); err != nil {
+			return e.JSON(http.StatusBadRequest, map[string]any{
+				"ok":      false,
+				"error":   "Invalid payload.",
+				"details": err.Error(),
+			})
+		}
+
+		rec, err := issueCreditNote(app, e, original, req, previewTemplatePath)
+		if err != nil {
+			var stageErr *invoiceStageError
+			if errors.As(err, &stageErr) {
+				return e.JSON(http.StatusInternalServerError, map[string]any{
+					"ok":      false,
+					"error":   "Failed to render credit note.",
+					"stage":   stageErr.Stage,
+					"details": err.Error(),
+				})
+			}
+			return e.JSON(http.StatusBadRequest, map[string]any{
+				"ok":      false,
+				"error":   "Failed to issue credit note.",
+				"details": err.Error(),
+			})
+		}
+
+		return e.JSON(http.StatusOK, map[string]any{
+			"ok":           true,
+			"creditNoteId": rec.Id,
+			"creditNoteNo": rec.GetString("invoiceNo"),
+			"grandTotal":   rec.GetFloat("grandTotal"),
+		})
+	}).Bind(apis.RequireAuth())
 }
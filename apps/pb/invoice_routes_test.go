@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+// issueTestInvoice creates the test order with one 250 frame and issues its first invoice
+// (INV-0001).
+func issueTestInvoice(t testing.TB, app core.App) *invoiceDocument {
+	frame := createTestFrame(t, app, map[string]any{"price": 250})
+
+	coll, err := app.FindCollectionByNameOrId("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := core.NewRecord(coll)
+	order.Id = testOrderId
+	order.Set("orderNo", 1234)
+	order.Set("frameOrderId", []string{frame.Id})
+	if err := app.Save(order); err != nil {
+		t.Fatal(err)
+	}
+
+	return reissueTestInvoice(t, app)
+}
+
+// reissueTestInvoice issues a new version of the test order's invoice from the order as stored.
+func reissueTestInvoice(t testing.TB, app core.App) *invoiceDocument {
+	src, err := loadOrderInvoiceSource(app, testOrderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := issueInvoiceDocument(app, nil, testOrderId, src.toInvoicePayload(), testPreviewTemplatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func bindInvoiceRoutesWithIssuedInvoice(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+	issueTestInvoice(t, app)
+	registerInvoiceRoutes(e, &pocketbase.PocketBase{App: app}, testPreviewTemplatePath)
+}
+
+func TestInvoicePreviewHasNoInvoiceNo(t *testing.T) {
+	scenario := tests.ApiScenario{
+		Name:           "recomputed content doesn't borrow the issued number",
+		Method:         http.MethodPost,
+		URL:            "/api/invoice/preview",
+		Body:           strings.NewReader(testInvoiceBody),
+		Headers:        superuserAuthHeader(t),
+		TestAppFactory: newEmailTestApp,
+		BeforeTestFunc: bindInvoiceRoutesWithIssuedInvoice,
+		ExpectedStatus: http.StatusOK,
+		ExpectedContent: []string{
+			"<strong>Invoice No:</strong> -</div>",
+		},
+		NotExpectedContent: []string{"INV-0001"},
+	}
+	scenario.Test(t)
+}
+
+func TestOrderInvoiceRoutesServeIssuedInvoice(t *testing.T) {
+	auth := superuserAuthHeader(t)
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "html of the issued invoice",
+			Method:          http.MethodGet,
+			URL:             "/api/orders/" + testOrderId + "/invoice",
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindInvoiceRoutesWithIssuedInvoice,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"<strong>Invoice No:</strong> INV-0001</div>"},
+		},
+		{
+			Name:            "stored pdf of the issued invoice",
+			Method:          http.MethodGet,
+			URL:             "/api/orders/" + testOrderId + "/invoice.pdf",
+			Headers:         auth,
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  bindInvoiceRoutesWithIssuedInvoice,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"%PDF"},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				if got := res.Header.Get("Content-Disposition"); got != `attachment; filename="invoice-INV-0001.pdf"` {
+					t.Errorf("expected the issued invoice's filename, got %q", got)
+				}
+
+				latest, err := findLatestIssuedInvoice(app, testOrderId)
+				if err != nil || latest == nil {
+					t.Fatalf("expected the issued invoice, got %v", err)
+				}
+				stored, err := readInvoicePdf(app, latest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := res.Header.Get("Content-Length"); got != strconv.Itoa(len(stored)) {
+					t.Errorf("expected the stored pdf (%d bytes), got %s bytes", len(stored), got)
+				}
+			},
+		},
+		{
+			Name:           "current figures once a payment has moved the balance",
+			Method:         http.MethodGet,
+			URL:            "/api/orders/" + testOrderId + "/invoice",
+			Headers:        auth,
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				bindInvoiceRoutesWithIssuedInvoice(t, app, e)
+				createTestPayment(t, app, testOrderId, 100)
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				"<strong>Invoice No:</strong> -</div>",
+				formatMoney(200),
+			},
+			NotExpectedContent: []string{"INV-0001"},
+		},
+		{
+			Name:           "rendered pdf once a payment has moved the balance",
+			Method:         http.MethodGet,
+			URL:            "/api/orders/" + testOrderId + "/invoice.pdf",
+			Headers:        auth,
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				bindInvoiceRoutesWithIssuedInvoice(t, app, e)
+				createTestPayment(t, app, testOrderId, 100)
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"%PDF"},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				if got := res.Header.Get("Content-Disposition"); got != `attachment; filename="invoice.pdf"` {
+					t.Errorf("expected the current figures, not the issued invoice, got %q", got)
+				}
+			},
+		},
+		{
+			Name:           "rendered pdf before an invoice is issued",
+			Method:         http.MethodGet,
+			URL:            "/api/orders/" + testOrderId + "/invoice.pdf",
+			Headers:        auth,
+			TestAppFactory: newEmailTestApp,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				bindEmailRoutesWithOrder(testPreviewTemplatePath)(t, app, e)
+				registerInvoiceRoutes(e, &pocketbase.PocketBase{App: app}, testPreviewTemplatePath)
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"%PDF"},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				if got := res.Header.Get("Content-Disposition"); got != `attachment; filename="invoice.pdf"` {
+					t.Errorf("expected an unnumbered filename, got %q", got)
+				}
+				assertInvoiceCount(t, app, 0)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestIssueCreditNote(t *testing.T) {
+	app := newTestApp(t)
+	pb := &pocketbase.PocketBase{App: app}
+	first := issueTestInvoice(t, app).Record
+
+	note, err := issueCreditNote(pb, nil, first, creditNoteRequest{Reason: "Cancelled"}, testPreviewTemplatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.GetString("invoiceNo") != "CN-0001" || note.GetFloat("grandTotal") != first.GetFloat("grandTotal") {
+		t.Errorf("expected CN-0001 for the whole invoice, got %s for %v", note.GetString("invoiceNo"), note.GetFloat("grandTotal"))
+	}
+	if _, err := readInvoicePdf(app, note); err != nil {
+		t.Errorf("expected the credit note pdf to be stored, got %v", err)
+	}
+
+	// re-issuing the invoice supersedes the credited version...
+	second := reissueTestInvoice(t, app)
+	first, err = app.FindRecordById("invoices", first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issueCreditNote(pb, nil, first, creditNoteRequest{}, testPreviewTemplatePath); err == nil {
+		t.Error("expected a superseded invoice to be rejected")
+	}
+
+	// ...but doesn't make room to credit the order again
+	if _, err := issueCreditNote(pb, nil, second.Record, creditNoteRequest{}, testPreviewTemplatePath); !errors.Is(err, errCreditExceedsInvoice) {
+		t.Errorf("expected errCreditExceedsInvoice, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+var (
+	mailClientMu       sync.RWMutex
+	mailClientOverride mailer.Mailer
+)
+
+// currentMailClient returns what the outbox sends through: the client set with setMailClient,
+// or the app's configured SMTP/sendmail client. The latter is resolved per call so changes to
+// the mail settings apply without a restart.
+func currentMailClient(app core.App) mailer.Mailer {
+	mailClientMu.RLock()
+	client := mailClientOverride
+	mailClientMu.RUnlock()
+
+	if client != nil {
+		return client
+	}
+	return app.NewMailClient()
+}
+
+// setMailClient overrides the mail client (tests, custom setups). nil restores the app's.
+func setMailClient(client mailer.Mailer) {
+	mailClientMu.Lock()
+	defer mailClientMu.Unlock()
+
+	mailClientOverride = client
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/plugins/jsvm"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
+
+	_ "precious-petals/pb-crm/migrations"
 )
 
 func main() {
@@ -22,12 +24,25 @@ func main() {
 
 	migratecmd.MustRegister(app, app.RootCmd, migratecmd.Config{})
 
+	registerOrderNumberHooks(app)
+	registerOrderStatusHooks(app)
+	registerFrameStageHooks(app)
+	registerCapacityHooks(app)
+	registerPaymentHooks(app)
+	registerEmailTemplateHooks(app)
+	registerStatusEmailHooks(app)
+	registerEmailBounceHooks(app)
+	registerPaymentReminderJob(app)
+
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
 		previewTemplatePath := resolvePathFromExecutable("pb_hooks", "views", "invoice.preview.html")
 
 		registerInvoiceRoutes(se, app, previewTemplatePath)
 		registerEmailRoutes(se, app, previewTemplatePath)
 		registerExportRoutes(se, app)
+		registerWorkshopRoutes(se, app)
+
+		startEmailOutboxWorker(app)
 
 		// serving SPA app
 		publicDir := resolvePathFromExecutable("pb_public")
@@ -36,6 +51,11 @@ func main() {
 		return se.Next()
 	})
 
+	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		stopEmailOutboxWorker()
+		return e.Next()
+	})
+
 	if err := app.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		panic(err)
 	}
@@ -0,0 +1,201 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// The domain collections were originally created through the dashboard.
+// This snapshot only creates them when missing (fresh/local installs and tests),
+// so later migrations can relate to them. Existing databases are left untouched.
+func init() {
+	m.Register(func(app core.App) error {
+		if !collectionExists(app, "order_frame_items") {
+			frames := core.NewBaseCollection("order_frame_items")
+			setAuthOnlyRules(frames)
+			frames.Fields.Add(
+				&core.TextField{Name: "sizeX"},
+				&core.TextField{Name: "sizeY"},
+				&core.SelectField{Name: "frameType", MaxSelect: 1, Values: []string{
+					"Black", "Dark wood gold line", "Oak", "Beech", "Cottage pine", "Bronze",
+					"Antique gold", "Speckled gold", "Antique silver", "Speckled silver",
+					"New modern silver", "Distressed white", "Modern white", "Distressed white wide",
+					"Pewter", "New pewter gunmetal", "Flat white", "Brushed silver", "Stone gold", "Stone silver",
+				}},
+				&core.SelectField{Name: "layout", MaxSelect: 1, Values: []string{
+					"Hand tied birds eve", "Hand tied side profile", "Hand tied side profile diagonal",
+					"Straight on shower or teardrop", "Meadow",
+				}},
+				&core.SelectField{Name: "preservationType", MaxSelect: 1, Values: []string{"3D", "pressed"}},
+				&core.SelectField{Name: "glassType", MaxSelect: 1, Values: []string{"Clearview uv glass", "Conservation glass"}},
+				&core.SelectField{Name: "frameMountColour", MaxSelect: 1, Values: []string{
+					"Cream - 8674", "Red - 8020", "Burgundy - 8151", "Gold - 8246", "Sage - 8633",
+					"Silver - 835", "Blue - 8168", "Purple - 8146", "Navy - 8687", "Pink - 8064",
+					"Maroon - 8016", "Light Grey - 8664", "Bright white - 897",
+				}},
+				&core.SelectField{Name: "inclusions", MaxSelect: 1, Values: []string{"Yes", "No", "Buttonhole"}},
+				&core.TextField{Name: "glassEngraving"},
+				&core.BoolField{Name: "artworkComplete"},
+				&core.BoolField{Name: "framingComplete"},
+				&core.DateField{Name: "preservationDate"},
+				&core.NumberField{Name: "price"},
+				&core.JSONField{Name: "extras"},
+				&core.TextField{Name: "special_notes"},
+			)
+			addAutodateFields(frames)
+			if err := app.Save(frames); err != nil {
+				return err
+			}
+		}
+
+		if !collectionExists(app, "order_paperweight_items") {
+			paperweights := core.NewBaseCollection("order_paperweight_items")
+			setAuthOnlyRules(paperweights)
+			paperweights.Fields.Add(
+				&core.NumberField{Name: "quantity", OnlyInt: true},
+				&core.NumberField{Name: "price"},
+				&core.BoolField{Name: "paperweightReceived"},
+			)
+			addAutodateFields(paperweights)
+			if err := app.Save(paperweights); err != nil {
+				return err
+			}
+		}
+
+		if !collectionExists(app, "orders") {
+			frames, err := app.FindCollectionByNameOrId("order_frame_items")
+			if err != nil {
+				return err
+			}
+			paperweights, err := app.FindCollectionByNameOrId("order_paperweight_items")
+			if err != nil {
+				return err
+			}
+
+			orders := core.NewBaseCollection("orders")
+			setAuthOnlyRules(orders)
+			orders.Fields.Add(
+				&core.NumberField{Name: "orderNo", OnlyInt: true},
+				&core.DateField{Name: "occasionDate"},
+				&core.SelectField{Name: "orderStatus", MaxSelect: 1, Values: []string{
+					"in_progress", "ready", "delivered", "cancelled", "draft",
+				}},
+				&core.SelectField{Name: "payment_status", MaxSelect: 1, Values: []string{
+					"waiting_first_deposit", "waiting_second_deposit", "waiting_final_balance",
+					"first_deposit_paid", "second_deposit_paid", "final_balance_paid",
+				}},
+				&core.TextField{Name: "notes"},
+				&core.RelationField{Name: "frameOrderId", CollectionId: frames.Id, MaxSelect: 999},
+				&core.RelationField{Name: "paperweightOrderId", CollectionId: paperweights.Id, MaxSelect: 1},
+				&core.BoolField{Name: "replacementFlowers"},
+				&core.NumberField{Name: "replacementFlowersQty"},
+				&core.NumberField{Name: "replacementFlowersPrice"},
+				&core.NumberField{Name: "collectionQty"},
+				&core.NumberField{Name: "collectionPrice"},
+				&core.NumberField{Name: "deliveryQty"},
+				&core.NumberField{Name: "deliveryPrice"},
+				&core.NumberField{Name: "recreateButtonholeQty"},
+				&core.NumberField{Name: "recreateButtonholePrice"},
+				&core.BoolField{Name: "returnUnusedFlowers"},
+				&core.NumberField{Name: "returnUnusedFlowersPrice"},
+				&core.NumberField{Name: "artistHours"},
+				&core.TextField{Name: "billingAddressLine1"},
+				&core.TextField{Name: "billingAddressLine2"},
+				&core.TextField{Name: "billingTown"},
+				&core.TextField{Name: "billingCounty"},
+				&core.TextField{Name: "billingPostcode"},
+				&core.BoolField{Name: "deliverySameAsBilling"},
+				&core.TextField{Name: "deliveryAddressLine1"},
+				&core.TextField{Name: "deliveryAddressLine2"},
+				&core.TextField{Name: "deliveryTown"},
+				&core.TextField{Name: "deliveryCounty"},
+				&core.TextField{Name: "deliveryPostcode"},
+			)
+			addAutodateFields(orders)
+			if err := app.Save(orders); err != nil {
+				return err
+			}
+		}
+
+		if !collectionExists(app, "customers") {
+			orders, err := app.FindCollectionByNameOrId("orders")
+			if err != nil {
+				return err
+			}
+
+			customers := core.NewBaseCollection("customers")
+			setAuthOnlyRules(customers)
+			customers.Fields.Add(
+				&core.SelectField{Name: "title", MaxSelect: 1, Values: []string{"Mrs", "Mr", "Miss"}},
+				&core.TextField{Name: "firstName"},
+				&core.TextField{Name: "surname"},
+				&core.EmailField{Name: "email"},
+				&core.TextField{Name: "telephone"},
+				&core.SelectField{Name: "howRecommended", MaxSelect: 1, Values: []string{
+					"Google", "Friend / Family", "Florist", "Wedding planner",
+				}},
+				&core.RelationField{Name: "orderId", CollectionId: orders.Id, MaxSelect: 1},
+			)
+			addAutodateFields(customers)
+			if err := app.Save(customers); err != nil {
+				return err
+			}
+		}
+
+		if !collectionExists(app, "email_logs") {
+			orders, err := app.FindCollectionByNameOrId("orders")
+			if err != nil {
+				return err
+			}
+			customers, err := app.FindCollectionByNameOrId("customers")
+			if err != nil {
+				return err
+			}
+			frames, err := app.FindCollectionByNameOrId("order_frame_items")
+			if err != nil {
+				return err
+			}
+			paperweights, err := app.FindCollectionByNameOrId("order_paperweight_items")
+			if err != nil {
+				return err
+			}
+			users, err := app.FindCollectionByNameOrId("users")
+			if err != nil {
+				return err
+			}
+
+			logs := core.NewBaseCollection("email_logs")
+			setAuthOnlyRules(logs)
+			logs.Fields.Add(
+				&core.TextField{Name: "channel"},
+				&core.SelectField{Name: "status", MaxSelect: 1, Values: []string{"attempted", "sent", "failed"}},
+				&core.DateField{Name: "sentAt"},
+				&core.TextField{Name: "error"},
+				&core.EmailField{Name: "toEmail"},
+				&core.TextField{Name: "toName"},
+				&core.TextField{Name: "subject"},
+				&core.TextField{Name: "templateKey"},
+				&core.SelectField{Name: "emailType", MaxSelect: 1, Values: []string{
+					"invoice", "recommendation_bouquet", "recommendation_paperweight", "status_update", "comment", "generic",
+				}},
+				&core.TextField{Name: "eventType", Required: true},
+				&core.TextField{Name: "eventNote"},
+				&core.RelationField{Name: "orderId", CollectionId: orders.Id, MaxSelect: 1},
+				&core.RelationField{Name: "customerId", CollectionId: customers.Id, MaxSelect: 1},
+				&core.RelationField{Name: "frameItemId", CollectionId: frames.Id, MaxSelect: 1},
+				&core.RelationField{Name: "paperweightItemId", CollectionId: paperweights.Id, MaxSelect: 1},
+				&core.RelationField{Name: "sentBy", CollectionId: users.Id, MaxSelect: 1},
+				&core.JSONField{Name: "meta"},
+			)
+			addAutodateFields(logs)
+			if err := app.Save(logs); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// never drop dashboard-created data on rollback
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// invoices are immutable snapshots of what was actually issued to the customer.
+func init() {
+	m.Register(func(app core.App) error {
+		orders, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			return err
+		}
+		customers, err := app.FindCollectionByNameOrId("customers")
+		if err != nil {
+			return err
+		}
+		emailLogs, err := app.FindCollectionByNameOrId("email_logs")
+		if err != nil {
+			return err
+		}
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		invoices := core.NewBaseCollection("invoices")
+		setAuthOnlyRules(invoices)
+		// documents are written by the server only and never edited afterwards
+		invoices.CreateRule = nil
+		invoices.UpdateRule = nil
+		invoices.DeleteRule = nil
+
+		invoices.Fields.Add(
+			&core.RelationField{Name: "orderId", CollectionId: orders.Id, MaxSelect: 1, Required: true},
+			&core.RelationField{Name: "customerId", CollectionId: customers.Id, MaxSelect: 1},
+			&core.RelationField{Name: "emailLogId", CollectionId: emailLogs.Id, MaxSelect: 999},
+			&core.RelationField{Name: "issuedBy", CollectionId: users.Id, MaxSelect: 1},
+			&core.TextField{Name: "invoiceNo", Required: true},
+			&core.NumberField{Name: "version", OnlyInt: true, Required: true},
+			&core.SelectField{Name: "status", MaxSelect: 1, Required: true, Values: []string{"issued", "superseded"}},
+			&core.DateField{Name: "issueDate", Required: true},
+			&core.NumberField{Name: "vatRate"},
+			&core.NumberField{Name: "subTotal"},
+			&core.NumberField{Name: "vatTotal"},
+			&core.NumberField{Name: "grandTotal"},
+			&core.JSONField{Name: "rows", MaxSize: 1 << 20},
+			&core.JSONField{Name: "snapshot", MaxSize: 1 << 20},
+			&core.TextField{Name: "html", Max: 1 << 20},
+			&core.FileField{Name: "pdf", MaxSelect: 1, MaxSize: 10 << 20, MimeTypes: []string{"application/pdf"}, Protected: true},
+		)
+		addAutodateFields(invoices)
+		invoices.AddIndex("idx_invoices_order_version", true, "orderId, version", "")
+
+		return app.Save(invoices)
+	}, func(app core.App) error {
+		return deleteCollectionIfExists(app, "invoices")
+	})
+}
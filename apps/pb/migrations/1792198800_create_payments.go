@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		orders, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			return err
+		}
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		payments := core.NewBaseCollection("payments")
+		setAuthOnlyRules(payments)
+		payments.Fields.Add(
+			&core.RelationField{Name: "orderId", CollectionId: orders.Id, MaxSelect: 1, Required: true, CascadeDelete: true},
+			&core.NumberField{Name: "amount", Required: true, Min: types.Pointer(0.01)},
+			&core.SelectField{Name: "method", MaxSelect: 1, Required: true, Values: []string{
+				"bank_transfer", "card", "cash", "cheque", "other",
+			}},
+			&core.SelectField{Name: "kind", MaxSelect: 1, Required: true, Values: []string{
+				"first_deposit", "second_deposit", "final_balance", "other",
+			}},
+			&core.DateField{Name: "paidAt", Required: true},
+			&core.TextField{Name: "reference"},
+			&core.TextField{Name: "notes"},
+			&core.RelationField{Name: "recordedBy", CollectionId: users.Id, MaxSelect: 1},
+		)
+		addAutodateFields(payments)
+		payments.AddIndex("idx_payments_order", false, "orderId", "")
+
+		return app.Save(payments)
+	}, func(app core.App) error {
+		return deleteCollectionIfExists(app, "payments")
+	})
+}
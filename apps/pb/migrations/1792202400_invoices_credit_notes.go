@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// credit notes live next to invoices so they share rendering, storage and email logging.
+func init() {
+	m.Register(func(app core.App) error {
+		invoices, err := app.FindCollectionByNameOrId("invoices")
+		if err != nil {
+			return err
+		}
+
+		invoices.Fields.Add(
+			&core.SelectField{Name: "kind", MaxSelect: 1, Values: []string{"invoice", "credit_note"}},
+			&core.RelationField{Name: "creditedInvoiceId", CollectionId: invoices.Id, MaxSelect: 1},
+			&core.NumberField{Name: "sequence", OnlyInt: true},
+			&core.TextField{Name: "reason"},
+		)
+
+		// versions only apply to invoices; credit notes are numbered on their own sequence
+		invoices.RemoveIndex("idx_invoices_order_version")
+		invoices.AddIndex("idx_invoices_order_version", true, "orderId, version", "kind = 'invoice'")
+		invoices.AddIndex("idx_invoices_credit_note_sequence", true, "sequence", "kind = 'credit_note'")
+
+		if err := app.Save(invoices); err != nil {
+			return err
+		}
+
+		if _, err := app.DB().NewQuery("UPDATE invoices SET kind = 'invoice' WHERE kind = '' OR kind IS NULL").Execute(); err != nil {
+			return err
+		}
+
+		return ensureSelectValues(app, "email_logs", "emailType", "credit_note")
+	}, func(app core.App) error {
+		invoices, err := app.FindCollectionByNameOrId("invoices")
+		if err != nil {
+			return err
+		}
+
+		invoices.RemoveIndex("idx_invoices_credit_note_sequence")
+		invoices.RemoveIndex("idx_invoices_order_version")
+		invoices.AddIndex("idx_invoices_order_version", true, "orderId, version", "")
+		invoices.Fields.RemoveByName("kind")
+		invoices.Fields.RemoveByName("creditedInvoiceId")
+		invoices.Fields.RemoveByName("sequence")
+		invoices.Fields.RemoveByName("reason")
+
+		return app.Save(invoices)
+	})
+}
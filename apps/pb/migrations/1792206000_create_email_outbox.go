@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// email_outbox holds fully rendered messages until the background worker delivers them.
+func init() {
+	m.Register(func(app core.App) error {
+		emailLogs, err := app.FindCollectionByNameOrId("email_logs")
+		if err != nil {
+			return err
+		}
+
+		outbox := core.NewBaseCollection("email_outbox")
+		// server-only; superusers can still inspect it from the dashboard
+		outbox.ListRule = nil
+		outbox.ViewRule = nil
+		outbox.CreateRule = nil
+		outbox.UpdateRule = nil
+		outbox.DeleteRule = nil
+
+		outbox.Fields.Add(
+			&core.RelationField{Name: "emailLogId", CollectionId: emailLogs.Id, MaxSelect: 1, CascadeDelete: true},
+			&core.SelectField{Name: "status", MaxSelect: 1, Required: true, Values: []string{"queued", "sending", "sent", "failed"}},
+			&core.EmailField{Name: "fromAddress"},
+			&core.TextField{Name: "fromName"},
+			&core.JSONField{Name: "to"},
+			&core.JSONField{Name: "cc"},
+			&core.JSONField{Name: "bcc"},
+			&core.JSONField{Name: "headers"},
+			&core.TextField{Name: "subject"},
+			&core.TextField{Name: "html", Max: 1 << 20},
+			&core.TextField{Name: "text", Max: 1 << 20},
+			&core.FileField{Name: "attachments", MaxSelect: 20, MaxSize: 20 << 20, Protected: true},
+			&core.JSONField{Name: "attachmentNames"},
+			&core.NumberField{Name: "attempts", OnlyInt: true},
+			&core.DateField{Name: "nextAttemptAt"},
+			&core.TextField{Name: "lastError"},
+		)
+		addAutodateFields(outbox)
+		outbox.AddIndex("idx_email_outbox_due", false, "status, nextAttemptAt", "")
+
+		if err := app.Save(outbox); err != nil {
+			return err
+		}
+
+		return ensureSelectValues(app, "email_logs", "status", "queued", "sending")
+	}, func(app core.App) error {
+		return deleteCollectionIfExists(app, "email_outbox")
+	})
+}
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		templates := core.NewBaseCollection("email_templates")
+		setAuthOnlyRules(templates)
+		templates.Fields.Add(
+			&core.TextField{Name: "templateKey", Required: true, Pattern: `^email\.[a-z0-9_-]+$`},
+			&core.TextField{Name: "description"},
+			&core.TextField{Name: "subject", Required: true},
+			&core.TextField{Name: "html", Required: true, Max: 1 << 20},
+			&core.TextField{Name: "text", Max: 1 << 20},
+			&core.BoolField{Name: "active"},
+			&core.NumberField{Name: "version", OnlyInt: true},
+			&core.RelationField{Name: "updatedBy", CollectionId: users.Id, MaxSelect: 1},
+		)
+		addAutodateFields(templates)
+		templates.AddIndex("idx_email_templates_key", true, "templateKey", "")
+
+		if err := app.Save(templates); err != nil {
+			return err
+		}
+
+		// one row per saved revision; written by the server only
+		versions := core.NewBaseCollection("email_template_versions")
+		setAuthOnlyRules(versions)
+		versions.CreateRule = nil
+		versions.UpdateRule = nil
+		versions.DeleteRule = nil
+		versions.Fields.Add(
+			&core.RelationField{Name: "templateId", CollectionId: templates.Id, MaxSelect: 1, Required: true, CascadeDelete: true},
+			&core.TextField{Name: "templateKey"},
+			&core.NumberField{Name: "version", OnlyInt: true},
+			&core.TextField{Name: "subject"},
+			&core.TextField{Name: "html", Max: 1 << 20},
+			&core.TextField{Name: "text", Max: 1 << 20},
+			&core.RelationField{Name: "updatedBy", CollectionId: users.Id, MaxSelect: 1},
+		)
+		addAutodateFields(versions)
+		versions.AddIndex("idx_email_template_versions_version", true, "templateId, version", "")
+
+		return app.Save(versions)
+	}, func(app core.App) error {
+		if err := deleteCollectionIfExists(app, "email_template_versions"); err != nil {
+			return err
+		}
+		return deleteCollectionIfExists(app, "email_templates")
+	})
+}
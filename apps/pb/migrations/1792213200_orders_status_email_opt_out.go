@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// per-order switch for the automatic status update emails
+func init() {
+	m.Register(func(app core.App) error {
+		orders, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			return err
+		}
+
+		orders.Fields.Add(&core.BoolField{Name: "statusEmailsOptOut"})
+
+		return app.Save(orders)
+	}, func(app core.App) error {
+		orders, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			return err
+		}
+
+		orders.Fields.RemoveByName("statusEmailsOptOut")
+
+		return app.Save(orders)
+	})
+}
@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// payment reminders are logged like any other email; the index stops a stage being
+// chased twice for the same order (failed attempts don't count, so they can be retried).
+func init() {
+	m.Register(func(app core.App) error {
+		if err := ensureSelectValues(app, "email_logs", "emailType", "payment_reminder"); err != nil {
+			return err
+		}
+
+		emailLogs, err := app.FindCollectionByNameOrId("email_logs")
+		if err != nil {
+			return err
+		}
+
+		emailLogs.AddIndex(
+			"idx_email_logs_payment_reminder_stage",
+			true,
+			"orderId, eventType",
+			"emailType = 'payment_reminder' AND status != 'failed'",
+		)
+
+		return app.Save(emailLogs)
+	}, func(app core.App) error {
+		emailLogs, err := app.FindCollectionByNameOrId("email_logs")
+		if err != nil {
+			return err
+		}
+
+		emailLogs.RemoveIndex("idx_email_logs_payment_reminder_stage")
+
+		return app.Save(emailLogs)
+	})
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// photos taken when the bouquet comes in; attached to the recommendation email
+func init() {
+	m.Register(func(app core.App) error {
+		frames, err := app.FindCollectionByNameOrId("order_frame_items")
+		if err != nil {
+			return err
+		}
+
+		frames.Fields.Add(&core.FileField{
+			Name:      "referenceImages",
+			MaxSelect: 10,
+			MaxSize:   10 << 20,
+			MimeTypes: []string{"image/jpeg", "image/png", "image/webp", "image/heic"},
+			Protected: true,
+		})
+
+		return app.Save(frames)
+	}, func(app core.App) error {
+		frames, err := app.FindCollectionByNameOrId("order_frame_items")
+		if err != nil {
+			return err
+		}
+
+		frames.Fields.RemoveByName("referenceImages")
+
+		return app.Save(frames)
+	})
+}
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// communications holds messages customers send us (email replies for now), linked to the
+// order they are about. Outgoing mail stays in email_logs.
+func init() {
+	m.Register(func(app core.App) error {
+		orders, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			return err
+		}
+		customers, err := app.FindCollectionByNameOrId("customers")
+		if err != nil {
+			return err
+		}
+
+		comms := core.NewBaseCollection("communications")
+		setAuthOnlyRules(comms)
+		// written by the inbound webhook; staff may only re-link or mark as read
+		comms.CreateRule = nil
+		comms.DeleteRule = nil
+
+		comms.Fields.Add(
+			&core.RelationField{Name: "orderId", CollectionId: orders.Id, MaxSelect: 1},
+			&core.RelationField{Name: "customerId", CollectionId: customers.Id, MaxSelect: 1},
+			&core.SelectField{Name: "channel", MaxSelect: 1, Required: true, Values: []string{"email"}},
+			&core.SelectField{Name: "direction", MaxSelect: 1, Required: true, Values: []string{"inbound"}},
+			&core.SelectField{Name: "matchedBy", MaxSelect: 1, Values: []string{"reply_tag", "invoice_no", "order_no", "sender", "unmatched"}},
+			&core.TextField{Name: "fromAddress"},
+			&core.TextField{Name: "fromName"},
+			&core.JSONField{Name: "to"},
+			&core.JSONField{Name: "cc"},
+			&core.TextField{Name: "subject"},
+			&core.TextField{Name: "text", Max: 1 << 20},
+			&core.TextField{Name: "html", Max: 1 << 20},
+			&core.TextField{Name: "messageId"},
+			&core.TextField{Name: "inReplyTo"},
+			&core.FileField{Name: "attachments", MaxSelect: 20, MaxSize: 20 << 20, Protected: true},
+			&core.JSONField{Name: "attachmentNames"},
+			&core.DateField{Name: "receivedAt"},
+			&core.BoolField{Name: "read"},
+		)
+		addAutodateFields(comms)
+		comms.AddIndex("idx_communications_order", false, "orderId, receivedAt", "")
+		// providers retry webhooks, so the same message must not be stored twice
+		comms.AddIndex("idx_communications_message_id", true, "messageId", "messageId != ''")
+
+		return app.Save(comms)
+	}, func(app core.App) error {
+		return deleteCollectionIfExists(app, "communications")
+	})
+}
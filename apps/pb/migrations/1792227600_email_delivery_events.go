@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// provider delivery events (see email_events.go) are matched to email_logs by the Message-ID we
+// send with, and hard bounces are flagged on the customer.
+func init() {
+	m.Register(func(app core.App) error {
+		if err := ensureSelectValues(app, "email_logs", "status", "delivered", "bounced", "complained"); err != nil {
+			return err
+		}
+
+		emailLogs, err := app.FindCollectionByNameOrId("email_logs")
+		if err != nil {
+			return err
+		}
+		emailLogs.Fields.Add(
+			&core.TextField{Name: "messageId"},
+			&core.DateField{Name: "deliveredAt"},
+			&core.DateField{Name: "openedAt"},
+		)
+		emailLogs.AddIndex("idx_email_logs_message_id", true, "messageId", "messageId != ''")
+		if err := app.Save(emailLogs); err != nil {
+			return err
+		}
+
+		customers, err := app.FindCollectionByNameOrId("customers")
+		if err != nil {
+			return err
+		}
+		customers.Fields.Add(
+			&core.BoolField{Name: "emailBounced"},
+			&core.DateField{Name: "emailBouncedAt"},
+			&core.TextField{Name: "emailBounceReason"},
+		)
+		return app.Save(customers)
+	}, func(app core.App) error {
+		emailLogs, err := app.FindCollectionByNameOrId("email_logs")
+		if err != nil {
+			return err
+		}
+		emailLogs.RemoveIndex("idx_email_logs_message_id")
+		emailLogs.Fields.RemoveByName("messageId")
+		emailLogs.Fields.RemoveByName("deliveredAt")
+		emailLogs.Fields.RemoveByName("openedAt")
+		if err := app.Save(emailLogs); err != nil {
+			return err
+		}
+
+		customers, err := app.FindCollectionByNameOrId("customers")
+		if err != nil {
+			return err
+		}
+		customers.Fields.RemoveByName("emailBounced")
+		customers.Fields.RemoveByName("emailBouncedAt")
+		customers.Fields.RemoveByName("emailBounceReason")
+		return app.Save(customers)
+	})
+}
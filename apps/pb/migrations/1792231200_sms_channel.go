@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// texts are logged in email_logs with channel = "sms". The reminder index now includes the
+// channel, so a stage can be chased once by email and once by text.
+func init() {
+	m.Register(func(app core.App) error {
+		emailLogs, err := app.FindCollectionByNameOrId("email_logs")
+		if err != nil {
+			return err
+		}
+		emailLogs.Fields.Add(&core.TextField{Name: "toPhone"})
+		emailLogs.RemoveIndex("idx_email_logs_payment_reminder_stage")
+		emailLogs.AddIndex(
+			"idx_email_logs_payment_reminder_stage",
+			true,
+			"orderId, eventType, channel",
+			"emailType = 'payment_reminder' AND status != 'failed'",
+		)
+		if err := app.Save(emailLogs); err != nil {
+			return err
+		}
+
+		customers, err := app.FindCollectionByNameOrId("customers")
+		if err != nil {
+			return err
+		}
+		customers.Fields.Add(&core.BoolField{Name: "smsOptIn"})
+		return app.Save(customers)
+	}, func(app core.App) error {
+		emailLogs, err := app.FindCollectionByNameOrId("email_logs")
+		if err != nil {
+			return err
+		}
+		emailLogs.RemoveIndex("idx_email_logs_payment_reminder_stage")
+		emailLogs.AddIndex(
+			"idx_email_logs_payment_reminder_stage",
+			true,
+			"orderId, eventType",
+			"emailType = 'payment_reminder' AND status != 'failed'",
+		)
+		emailLogs.Fields.RemoveByName("toPhone")
+		if err := app.Save(emailLogs); err != nil {
+			return err
+		}
+
+		customers, err := app.FindCollectionByNameOrId("customers")
+		if err != nil {
+			return err
+		}
+		customers.Fields.RemoveByName("smsOptIn")
+		return app.Save(customers)
+	})
+}
@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// order numbers are now allocated from a counter in the create transaction (see order_numbers.go)
+// instead of the old "highest orderNo + 1" JS hook, and are unique.
+func init() {
+	m.Register(func(app core.App) error {
+		// named counters, written by the server only
+		sequences := core.NewBaseCollection("sequences")
+		sequences.Fields.Add(
+			&core.TextField{Name: "name", Required: true},
+			&core.NumberField{Name: "value", OnlyInt: true},
+		)
+		addAutodateFields(sequences)
+		sequences.AddIndex("idx_sequences_name", true, "name", "")
+
+		if err := app.Save(sequences); err != nil {
+			return err
+		}
+
+		if err := renumberDuplicateOrderNos(app); err != nil {
+			return err
+		}
+
+		orders, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			return err
+		}
+		orders.Fields.Add(&core.TextField{Name: "orderRef"})
+		orders.AddIndex("idx_orders_order_no", true, "orderNo", "orderNo > 0")
+		orders.AddIndex("idx_orders_order_ref", true, "orderRef", "orderRef != ''")
+		if err := app.Save(orders); err != nil {
+			return err
+		}
+
+		maxNo, err := maxOrderNo(app)
+		if err != nil {
+			return err
+		}
+		seq := core.NewRecord(sequences)
+		seq.Set("name", "orders.orderNo")
+		seq.Set("value", maxNo)
+		return app.Save(seq)
+	}, func(app core.App) error {
+		orders, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			return err
+		}
+		orders.RemoveIndex("idx_orders_order_no")
+		orders.RemoveIndex("idx_orders_order_ref")
+		orders.Fields.RemoveByName("orderRef")
+		if err := app.Save(orders); err != nil {
+			return err
+		}
+
+		return deleteCollectionIfExists(app, "sequences")
+	})
+}
+
+func maxOrderNo(app core.App) (int, error) {
+	var result struct {
+		Value int `db:"value"`
+	}
+	err := app.DB().NewQuery("SELECT COALESCE(MAX([[orderNo]]), 0) AS [[value]] FROM {{orders}}").One(&result)
+	return result.Value, err
+}
+
+// renumberDuplicateOrderNos lets the unique index go on. The old hook could give two orders
+// created together the same number: the first created keeps it, the others move to the end.
+func renumberDuplicateOrderNos(app core.App) error {
+	var rows []struct {
+		Id      string `db:"id"`
+		OrderNo int    `db:"orderNo"`
+	}
+	err := app.DB().NewQuery(`
+		SELECT [[id]], [[orderNo]] FROM {{orders}}
+		WHERE [[orderNo]] IN (
+			SELECT [[orderNo]] FROM {{orders}} WHERE [[orderNo]] > 0 GROUP BY [[orderNo]] HAVING COUNT(*) > 1
+		)
+		ORDER BY [[orderNo]], [[created]], [[id]]
+	`).All(&rows)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+
+	next, err := maxOrderNo(app)
+	if err != nil {
+		return err
+	}
+
+	kept := map[int]bool{}
+	for _, row := range rows {
+		if !kept[row.OrderNo] {
+			kept[row.OrderNo] = true
+			continue
+		}
+
+		next++
+		if _, err := app.DB().Update("orders", dbx.Params{"orderNo": next}, dbx.HashExp{"id": row.Id}).Execute(); err != nil {
+			return err
+		}
+		app.Logger().Warn("renumbered duplicate orderNo", "orderId", row.Id, "from", row.OrderNo, "to", next)
+	}
+	return nil
+}
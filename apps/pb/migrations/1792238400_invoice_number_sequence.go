@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// invoices get their numbers from their own counter (see invoice_numbers.go) instead of
+// printing the orderNo. Orders invoiced before this get the first places in the sequence, in
+// the order they were first invoiced, so new numbers carry on after them. Before the invoices
+// collection the only record of an invoice is the email that sent it, so those count too. The
+// invoiceNo already sent is left alone since that is what the customer has.
+func init() {
+	m.Register(func(app core.App) error {
+		var orders []struct {
+			OrderId   string `db:"orderId"`
+			FirstYear string `db:"firstYear"`
+		}
+		err := app.DB().NewQuery(`
+			SELECT [[invoiced.orderId]] AS [[orderId]], substr(MIN([[invoiced.at]]), 1, 4) AS [[firstYear]]
+			FROM (
+				SELECT [[orderId]], [[created]] AS [[at]]
+				FROM {{invoices}}
+				WHERE [[kind]] = 'invoice' AND [[orderId]] != ''
+				UNION ALL
+				SELECT [[orderId]], [[created]] AS [[at]]
+				FROM {{email_logs}}
+				WHERE [[emailType]] = 'invoice' AND [[status]] != 'failed' AND [[orderId]] != ''
+			) invoiced
+			LEFT JOIN {{orders}} ON [[orders.id]] = [[invoiced.orderId]]
+			GROUP BY [[invoiced.orderId]]
+			ORDER BY MIN([[invoiced.at]]), COALESCE([[orders.orderNo]], 0), [[invoiced.orderId]]
+		`).All(&orders)
+		if err != nil {
+			return err
+		}
+
+		// a yearly reset (INVOICE_NO_RESET) counts per year, so seed those counters too
+		total := 0
+		perYear := map[string]int{}
+		for _, order := range orders {
+			total++
+			perYear[order.FirstYear]++
+
+			_, err := app.DB().Update(
+				"invoices",
+				dbx.Params{"sequence": total},
+				dbx.NewExp("[[orderId]] = {:orderId} AND [[kind]] = 'invoice'", dbx.Params{"orderId": order.OrderId}),
+			).Execute()
+			if err != nil {
+				return err
+			}
+		}
+
+		sequences, err := app.FindCollectionByNameOrId("sequences")
+		if err != nil {
+			return err
+		}
+		seed := func(name string, value int) error {
+			rec := core.NewRecord(sequences)
+			rec.Set("name", name)
+			rec.Set("value", value)
+			return app.Save(rec)
+		}
+
+		if err := seed("invoices.invoiceNo", total); err != nil {
+			return err
+		}
+		for year, count := range perYear {
+			if err := seed("invoices.invoiceNo."+year, count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(app core.App) error {
+		_, err := app.DB().Update("invoices", dbx.Params{"sequence": 0}, dbx.HashExp{"kind": "invoice"}).Execute()
+		if err != nil {
+			return err
+		}
+		_, err = app.DB().Delete("sequences", dbx.Or(
+			dbx.HashExp{"name": "invoices.invoiceNo"},
+			dbx.Like("name", "invoices.invoiceNo.").Match(false, true),
+		)).Execute()
+		return err
+	})
+}
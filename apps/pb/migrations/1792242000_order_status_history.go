@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// order_status_history gets a row for every orderStatus change (see order_status.go).
+func init() {
+	m.Register(func(app core.App) error {
+		orders, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			return err
+		}
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		statuses := []string{"draft", "in_progress", "ready", "delivered", "cancelled"}
+
+		// written by the server only
+		history := core.NewBaseCollection("order_status_history")
+		setAuthOnlyRules(history)
+		history.CreateRule = nil
+		history.UpdateRule = nil
+		history.DeleteRule = nil
+		history.Fields.Add(
+			&core.RelationField{Name: "orderId", CollectionId: orders.Id, MaxSelect: 1, Required: true, CascadeDelete: true},
+			// empty for the status an order was created with
+			&core.SelectField{Name: "fromStatus", MaxSelect: 1, Values: statuses},
+			&core.SelectField{Name: "toStatus", MaxSelect: 1, Required: true, Values: statuses},
+			&core.RelationField{Name: "changedBy", CollectionId: users.Id, MaxSelect: 1},
+			// also set for superusers, who can't be linked through changedBy
+			&core.TextField{Name: "changedByEmail"},
+			&core.TextField{Name: "reason", Max: 1000},
+			&core.DateField{Name: "changedAt", Required: true},
+		)
+		addAutodateFields(history)
+		history.AddIndex("idx_order_status_history_order", false, "orderId, changedAt", "")
+
+		return app.Save(history)
+	}, func(app core.App) error {
+		return deleteCollectionIfExists(app, "order_status_history")
+	})
+}
@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+var frameStageDateFields = []string{
+	"receivedAt",
+	"preservedAt",
+	"artworkAt",
+	"mountedAt",
+	"framedAt",
+	"qualityCheckedAt",
+	"collectedAt",
+}
+
+// frames move through the workshop stages in frame_stages.go. Existing frames are placed from
+// the two booleans (and preservationDate); their stage timestamps stay empty since we don't
+// know when those happened.
+func init() {
+	m.Register(func(app core.App) error {
+		frames, err := app.FindCollectionByNameOrId("order_frame_items")
+		if err != nil {
+			return err
+		}
+
+		frames.Fields.Add(&core.SelectField{Name: "stage", MaxSelect: 1, Values: []string{
+			"received", "preserved", "artwork", "mounted", "framed", "quality_checked", "collected",
+		}})
+		for _, name := range frameStageDateFields {
+			frames.Fields.Add(&core.DateField{Name: name})
+		}
+		frames.AddIndex("idx_order_frame_items_stage", false, "stage", "")
+
+		if err := app.Save(frames); err != nil {
+			return err
+		}
+
+		_, err = app.DB().NewQuery(`
+			UPDATE {{order_frame_items}} SET [[stage]] = CASE
+				WHEN [[framingComplete]] THEN 'framed'
+				WHEN [[artworkComplete]] THEN 'artwork'
+				WHEN [[preservationDate]] != '' THEN 'preserved'
+				ELSE 'received'
+			END
+			WHERE [[stage]] = ''
+		`).Execute()
+		return err
+	}, func(app core.App) error {
+		frames, err := app.FindCollectionByNameOrId("order_frame_items")
+		if err != nil {
+			return err
+		}
+
+		frames.RemoveIndex("idx_order_frame_items_stage")
+		frames.Fields.RemoveByName("stage")
+		for _, name := range frameStageDateFields {
+			frames.Fields.RemoveByName(name)
+		}
+
+		return app.Save(frames)
+	})
+}
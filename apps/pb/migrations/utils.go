@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// staff-only access, same as the collections created through the dashboard
+const authOnlyRule = `@request.auth.id != ""`
+
+func setAuthOnlyRules(collection *core.Collection) {
+	collection.ListRule = types.Pointer(authOnlyRule)
+	collection.ViewRule = types.Pointer(authOnlyRule)
+	collection.CreateRule = types.Pointer(authOnlyRule)
+	collection.UpdateRule = types.Pointer(authOnlyRule)
+	collection.DeleteRule = types.Pointer(authOnlyRule)
+}
+
+func addAutodateFields(collection *core.Collection) {
+	collection.Fields.Add(
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+}
+
+func collectionExists(app core.App, name string) bool {
+	_, err := app.FindCollectionByNameOrId(name)
+	return err == nil
+}
+
+// deleteCollectionIfExists is used by down migrations so they can be re-run safely.
+func deleteCollectionIfExists(app core.App, name string) error {
+	collection, err := app.FindCollectionByNameOrId(name)
+	if err != nil {
+		return nil
+	}
+	return app.Delete(collection)
+}
+
+// ensureSelectValues appends any missing values to an existing select field.
+func ensureSelectValues(app core.App, collectionName, fieldName string, values ...string) error {
+	collection, err := app.FindCollectionByNameOrId(collectionName)
+	if err != nil {
+		return err
+	}
+
+	field, ok := collection.Fields.GetByName(fieldName).(*core.SelectField)
+	if !ok {
+		return nil
+	}
+
+	existing := make(map[string]bool, len(field.Values))
+	for _, value := range field.Values {
+		existing[value] = true
+	}
+
+	changed := false
+	for _, value := range values {
+		if !existing[value] {
+			field.Values = append(field.Values, value)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return app.Save(collection)
+}
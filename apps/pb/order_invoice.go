@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var errOrderNotFound = errors.New("order not found")
+
+// orderInvoiceSource holds the stored records an invoice is built from.
+type orderInvoiceSource struct {
+	Order       *core.Record
+	Customer    *core.Record
+	Frames      []*core.Record
+	Paperweight *core.Record
+}
+
+// loadOrderInvoiceSource loads the order and its related records the same way the XLSX export does.
+func loadOrderInvoiceSource(app core.App, orderId string) (*orderInvoiceSource, error) {
+	orderId = strings.TrimSpace(orderId)
+	if orderId == "" {
+		return nil, errOrderNotFound
+	}
+
+	order, err := app.FindRecordById("orders", orderId)
+	if err != nil {
+		return nil, errOrderNotFound
+	}
+
+	src := &orderInvoiceSource{Order: order}
+
+	customers, err := fetchRecordsByField(app, "customers", "orderId", []string{order.Id})
+	if err != nil {
+		return nil, fmt.Errorf("load customer: %w", err)
+	}
+	if len(customers) > 0 {
+		src.Customer = customers[0]
+	}
+
+	// keep the frame order as stored on the relation so item numbering is stable
+	frameIds := order.GetStringSlice("frameOrderId")
+	frames, err := fetchRecordsByIds(app, "order_frame_items", frameIds)
+	if err != nil {
+		return nil, fmt.Errorf("load frame items: %w", err)
+	}
+	frameById := make(map[string]*core.Record, len(frames))
+	for _, frame := range frames {
+		frameById[frame.Id] = frame
+	}
+	for _, frameId := range frameIds {
+		if frame, ok := frameById[frameId]; ok {
+			src.Frames = append(src.Frames, frame)
+		}
+	}
+
+	if pwId := strings.TrimSpace(order.GetString("paperweightOrderId")); pwId != "" {
+		pw, err := app.FindRecordById("order_paperweight_items", pwId)
+		if err != nil {
+			return nil, fmt.Errorf("load paperweight item: %w", err)
+		}
+		src.Paperweight = pw
+	}
+
+	return src, nil
+}
+
+func numberOf(value float64) Number {
+	return Number{Val: &value}
+}
+
+// recordDate turns a PB datetime ("2006-01-02 15:04:05.000Z") into "YYYY-MM-DD".
+func recordDate(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if len(trimmed) >= 10 {
+		return trimmed[:10]
+	}
+	return trimmed
+}
+
+// toInvoicePayload maps stored records onto the same payload shape the FE posts,
+// so every renderer downstream stays oblivious to where the data came from.
+func (src *orderInvoiceSource) toInvoicePayload() invoicePayload {
+	var payload invoicePayload
+	order := src.Order
+
+	if c := src.Customer; c != nil {
+		payload.Customer.ID = c.Id
+		payload.Customer.Title = c.GetString("title")
+		payload.Customer.FirstName = c.GetString("firstName")
+		payload.Customer.Surname = c.GetString("surname")
+		payload.Customer.Email = c.GetString("email")
+		payload.Customer.PhoneNumber = c.GetString("telephone")
+	}
+
+	payload.Order.OrderID = order.Id
+	if orderNo := order.GetInt("orderNo"); orderNo > 0 {
+		payload.Order.OrderNo = numberOf(float64(orderNo))
+	}
+	payload.Order.OrderRef = order.GetString("orderRef")
+	payload.Order.OccasionDate = StringDate(recordDate(order.GetString("occasionDate")))
+	payload.Order.Created = recordDate(order.GetString("created"))
+	payload.Order.BillingAddressLine1 = order.GetString("billingAddressLine1")
+	payload.Order.BillingAddressLine2 = order.GetString("billingAddressLine2")
+	payload.Order.BillingTown = order.GetString("billingTown")
+	payload.Order.BillingCounty = order.GetString("billingCounty")
+	payload.Order.BillingPostcode = order.GetString("billingPostcode")
+
+	extras := &orderExtrasPayload{
+		ReplacementFlowers:  order.GetBool("replacementFlowers"),
+		ReturnUnusedFlowers: order.GetBool("returnUnusedFlowers"),
+		Notes:               order.GetString("notes"),
+	}
+	setIfPositive := func(dst *Number, field string) {
+		if value := order.GetFloat(field); value > 0 {
+			*dst = numberOf(value)
+		}
+	}
+	setIfPositive(&extras.ReplacementFlowersQty, "replacementFlowersQty")
+	setIfPositive(&extras.ReplacementFlowersPrice, "replacementFlowersPrice")
+	setIfPositive(&extras.CollectionQty, "collectionQty")
+	setIfPositive(&extras.CollectionPrice, "collectionPrice")
+	setIfPositive(&extras.DeliveryQty, "deliveryQty")
+	setIfPositive(&extras.DeliveryPrice, "deliveryPrice")
+	setIfPositive(&extras.ReturnUnusedFlowersPrice, "returnUnusedFlowersPrice")
+	setIfPositive(&extras.ArtistHours, "artistHours")
+	payload.OrderExtras = extras
+
+	for _, frame := range src.Frames {
+		fp := framePayload{
+			FrameID:        frame.Id,
+			Layout:         frame.GetString("layout"),
+			FrameType:      frame.GetString("frameType"),
+			GlassType:      frame.GetString("glassType"),
+			Inclusions:     frame.GetString("inclusions"),
+			MountColour:    frame.GetString("frameMountColour"),
+			GlassEngraving: frame.GetString("glassEngraving"),
+			Price:          numberOf(frame.GetFloat("price")),
+		}
+		if sizeX, sizeY := frame.GetString("sizeX"), frame.GetString("sizeY"); sizeX != "" || sizeY != "" {
+			fp.Size = fmt.Sprintf("%sx%s inches", sizeX, sizeY)
+		}
+
+		frameExtras := readExtrasMap(frame.Get("extras"))
+		fe := &frameExtrasPayload{}
+		for key, dst := range map[string]*Number{
+			"framePrice":              &fe.FramePrice,
+			"mountPrice":              &fe.MountPrice,
+			"glassPrice":              &fe.GlassPrice,
+			"glassEngravingPrice":     &fe.GlassEngravingPrice,
+			"measuredWidthIn":         &fe.MeasuredWidthIn,
+			"measuredHeightIn":        &fe.MeasuredHeightIn,
+			"recommendedSizeWidthIn":  &fe.RecommendedSizeWidthIn,
+			"recommendedSizeHeightIn": &fe.RecommendedSizeHeightIn,
+		} {
+			if value, ok := coerceFloat(frameExtras[key]); ok {
+				*dst = numberOf(value)
+			}
+		}
+		fp.Extras = fe
+
+		payload.Frames = append(payload.Frames, fp)
+	}
+
+	if pw := src.Paperweight; pw != nil {
+		payload.Paperweight = &paperweightPayload{
+			Quantity: numberOf(float64(pw.GetInt("quantity"))),
+			Price:    numberOf(pw.GetFloat("price")),
+		}
+	}
+
+	return payload
+}
+
+// buildOrderInvoiceView prices the stored order and applies the payments ledger. The result is
+// unissued, so its InvoiceNo is "-"; issued invoices are served from the invoices collection.
+func buildOrderInvoiceView(app core.App, src *orderInvoiceSource) (invoiceViewModel, error) {
+	payments, err := loadInvoicePayments(app, src.Order.Id)
+	if err != nil {
+		return invoiceViewModel{}, fmt.Errorf("load payments: %w", err)
+	}
+	return buildInvoiceViewModel(src.toInvoicePayload(), payments), nil
+}
+
+func orderInvoiceSourceError(e *core.RequestEvent, err error) error {
+	if errors.Is(err, errOrderNotFound) {
+		return e.JSON(http.StatusNotFound, map[string]any{
+			"ok":    false,
+			"error": "Order not found.",
+		})
+	}
+	return e.JSON(http.StatusInternalServerError, map[string]any{
+		"ok":      false,
+		"error":   "Failed to load order.",
+		"details": err.Error(),
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const orderNoSequence = "orders.orderNo"
+
+var sequenceFormatToken = regexp.MustCompile(`\{(YYYY|YY|MM|SEQ)(?::(\d+))?\}`)
+
+// registerOrderNumberHooks numbers new orders. The counter is bumped in the same transaction as
+// the insert, so two orders created together can't get the same number and a failed create
+// doesn't use one up. An orderNo set by hand (eg an imported order) is kept.
+func registerOrderNumberHooks(app *pocketbase.PocketBase) {
+	app.OnRecordCreate("orders").BindFunc(func(e *core.RecordEvent) error {
+		// e.App is put back afterwards so the after-success hooks don't get the committed transaction
+		originalApp := e.App
+		txErr := e.App.RunInTransaction(func(txApp core.App) error {
+			e.App = txApp
+
+			if err := assignOrderNumber(txApp, e.Record, time.Now()); err != nil {
+				return fmt.Errorf("assign order number: %w", err)
+			}
+			return e.Next()
+		})
+		e.App = originalApp
+
+		return txErr
+	})
+}
+
+func assignOrderNumber(app core.App, order *core.Record, now time.Time) error {
+	orderNo := order.GetInt("orderNo")
+	if orderNo > 0 {
+		// keep the counter ahead of it so the next allocated number doesn't collide
+		if err := raiseSequence(app, orderNoSequence, orderNo, seedOrderNoSequence); err != nil {
+			return err
+		}
+	} else {
+		next, err := nextSequenceValue(app, orderNoSequence, seedOrderNoSequence)
+		if err != nil {
+			return err
+		}
+		orderNo = next
+		order.Set("orderNo", orderNo)
+	}
+
+	if strings.TrimSpace(order.GetString("orderRef")) == "" {
+		if format := resolveOrderRefFormat(); format != "" {
+			order.Set("orderRef", formatSequenceNumber(format, orderNo, now))
+		}
+	}
+	return nil
+}
+
+// resolveOrderRefFormat reads ORDER_REF_FORMAT, eg "PP-{YYYY}-{SEQ:4}". Unset means orders only
+// get the plain orderNo. A format without {SEQ} would repeat, so the number is appended.
+func resolveOrderRefFormat() string {
+	format := strings.TrimSpace(os.Getenv("ORDER_REF_FORMAT"))
+	if format != "" && !strings.Contains(format, "{SEQ") {
+		format += "{SEQ}"
+	}
+	return format
+}
+
+// formatSequenceNumber expands {YYYY}, {YY}, {MM} and {SEQ} in format. {SEQ:4} zero-pads the
+// number to 4 digits; longer numbers are never cut.
+func formatSequenceNumber(format string, seq int, at time.Time) string {
+	return sequenceFormatToken.ReplaceAllStringFunc(format, func(token string) string {
+		parts := sequenceFormatToken.FindStringSubmatch(token)
+		switch parts[1] {
+		case "YYYY":
+			return at.Format("2006")
+		case "YY":
+			return at.Format("06")
+		case "MM":
+			return at.Format("01")
+		}
+
+		width, _ := strconv.Atoi(parts[2])
+		return fmt.Sprintf("%0*d", width, seq)
+	})
+}
+
+// seedOrderNoSequence starts a missing counter after the highest orderNo already in use.
+func seedOrderNoSequence(app core.App) (int, error) {
+	var result struct {
+		Value int `db:"value"`
+	}
+	err := app.DB().NewQuery("SELECT COALESCE(MAX([[orderNo]]), 0) AS [[value]] FROM {{orders}}").One(&result)
+	return result.Value, err
+}
+
+// findSequence loads the named counter, or a new unsaved one starting at seed.
+func findSequence(app core.App, name string, seed func(core.App) (int, error)) (*core.Record, error) {
+	rec, err := app.FindFirstRecordByData("sequences", "name", name)
+	if err == nil {
+		return rec, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	coll, err := app.FindCollectionByNameOrId("sequences")
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if seed != nil {
+		if start, err = seed(app); err != nil {
+			return nil, err
+		}
+	}
+
+	rec = core.NewRecord(coll)
+	rec.Set("name", name)
+	rec.Set("value", start)
+	return rec, nil
+}
+
+// nextSequenceValue increments the named counter and returns the new value. Call it inside a
+// transaction together with the write that uses the value.
+func nextSequenceValue(app core.App, name string, seed func(core.App) (int, error)) (int, error) {
+	rec, err := findSequence(app, name, seed)
+	if err != nil {
+		return 0, err
+	}
+
+	next := rec.GetInt("value") + 1
+	rec.Set("value", next)
+	if err := app.Save(rec); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// raiseSequence moves the counter up to value when a number was assigned by hand.
+func raiseSequence(app core.App, name string, value int, seed func(core.App) (int, error)) error {
+	rec, err := findSequence(app, name, seed)
+	if err != nil {
+		return err
+	}
+	if !rec.IsNew() && rec.GetInt("value") >= value {
+		return nil
+	}
+	if rec.GetInt("value") < value {
+		rec.Set("value", value)
+	}
+	return app.Save(rec)
+}
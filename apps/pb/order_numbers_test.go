@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func createTestOrder(t testing.TB, app core.App, orderNo int) *core.Record {
+	coll, err := app.FindCollectionByNameOrId("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := core.NewRecord(coll)
+	if orderNo > 0 {
+		order.Set("orderNo", orderNo)
+	}
+	if err := app.Save(order); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestOrderNumbersAreSequential(t *testing.T) {
+	app := newTestApp(t, registerOrderNumberHooks)
+
+	first := createTestOrder(t, app, 0)
+	second := createTestOrder(t, app, 0)
+
+	if first.GetInt("orderNo") != 1 || second.GetInt("orderNo") != 2 {
+		t.Fatalf("expected 1 and 2, got %d and %d", first.GetInt("orderNo"), second.GetInt("orderNo"))
+	}
+	if first.GetString("orderRef") != "" {
+		t.Errorf("expected no orderRef without ORDER_REF_FORMAT, got %q", first.GetString("orderRef"))
+	}
+}
+
+func TestOrderNumbersLeaveAfterCreateHooksAUsableApp(t *testing.T) {
+	app := newTestApp(t, registerOrderNumberHooks)
+
+	var lookupErr error
+	app.OnRecordAfterCreateSuccess("orders").BindFunc(func(e *core.RecordEvent) error {
+		_, lookupErr = e.App.FindRecordById("orders", e.Record.Id)
+		return e.Next()
+	})
+
+	createTestOrder(t, app, 0)
+	if lookupErr != nil {
+		t.Errorf("expected the new order to be readable after create, got %v", lookupErr)
+	}
+}
+
+func TestOrderNumbersKeepManualNumber(t *testing.T) {
+	app := newTestApp(t, registerOrderNumberHooks)
+
+	manual := createTestOrder(t, app, 500)
+	next := createTestOrder(t, app, 0)
+
+	if manual.GetInt("orderNo") != 500 {
+		t.Errorf("expected the manual orderNo to be kept, got %d", manual.GetInt("orderNo"))
+	}
+	if next.GetInt("orderNo") != 501 {
+		t.Errorf("expected the next order to follow the manual one, got %d", next.GetInt("orderNo"))
+	}
+
+	// a lower manual number doesn't move the counter back
+	createTestOrder(t, app, 10)
+	if no := createTestOrder(t, app, 0).GetInt("orderNo"); no != 502 {
+		t.Errorf("expected 502, got %d", no)
+	}
+
+	coll, _ := app.FindCollectionByNameOrId("orders")
+	duplicate := core.NewRecord(coll)
+	duplicate.Set("orderNo", 500)
+	if err := app.Save(duplicate); err == nil {
+		t.Error("expected a duplicate orderNo to be rejected")
+	}
+}
+
+func TestOrderNumbersConcurrentCreates(t *testing.T) {
+	app := newTestApp(t, registerOrderNumberHooks)
+
+	const total = 20
+	numbers := make(chan int, total)
+
+	var wg sync.WaitGroup
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			numbers <- createTestOrder(t, app, 0).GetInt("orderNo")
+		}()
+	}
+	wg.Wait()
+	close(numbers)
+
+	seen := map[int]bool{}
+	for no := range numbers {
+		if seen[no] {
+			t.Fatalf("orderNo %d allocated twice", no)
+		}
+		seen[no] = true
+	}
+	for no := 1; no <= total; no++ {
+		if !seen[no] {
+			t.Errorf("expected orderNo %d to be allocated", no)
+		}
+	}
+}
+
+func TestOrderRefFormat(t *testing.T) {
+	t.Setenv("ORDER_REF_FORMAT", "PP-{YYYY}-{SEQ:4}")
+	app := newTestApp(t, registerOrderNumberHooks)
+
+	order := createTestOrder(t, app, 42)
+	expected := "PP-" + time.Now().Format("2006") + "-0042"
+	if order.GetString("orderRef") != expected {
+		t.Errorf("expected orderRef %q, got %q", expected, order.GetString("orderRef"))
+	}
+}
+
+func TestFormatSequenceNumber(t *testing.T) {
+	at := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+
+	scenarios := []struct {
+		format   string
+		seq      int
+		expected string
+	}{
+		{"{SEQ}", 7, "7"},
+		{"PP-{YYYY}-{SEQ:4}", 42, "PP-2026-0042"},
+		{"PP{YY}{MM}/{SEQ:3}", 12345, "PP2603/12345"},
+		{"{SEQ:2}-{UNKNOWN}", 3, "03-{UNKNOWN}"},
+	}
+
+	for _, s := range scenarios {
+		if got := formatSequenceNumber(s.format, s.seq, at); got != s.expected {
+			t.Errorf("%s: expected %q, got %q", s.format, s.expected, got)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// orderStatusTransitions lists where each status can go next. Delivered is final and a
+// cancelled order can only be reopened as a draft.
+var orderStatusTransitions = map[string][]string{
+	"draft":       {"in_progress", "cancelled"},
+	"in_progress": {"ready", "draft", "cancelled"},
+	"ready":       {"delivered", "in_progress", "cancelled"},
+	"delivered":   {},
+	"cancelled":   {"draft"},
+}
+
+const (
+	// optional request body field saying why the status changed; it isn't stored on the order
+	orderStatusReasonField = "statusReason"
+
+	// custom record data handing the request details to the model hooks; PocketBase never
+	// exports "@pbInternal" keys in responses
+	orderStatusAuthKey   = "@pbInternalStatusChangedBy"
+	orderStatusReasonKey = "@pbInternalStatusReason"
+
+	// the statuses a server-side save (the frame roll-up) steps through to reach the new one,
+	// each validated and written to the history
+	orderStatusStepsKey = "@pbInternalStatusSteps"
+)
+
+// registerOrderStatusHooks rejects orderStatus changes not in orderStatusTransitions and writes
+// every change to order_status_history in the same transaction as the order.
+func registerOrderStatusHooks(app *pocketbase.PocketBase) {
+	// the model hooks below don't see the request, so who is asking and why ride along on the record
+	passStatusChangeContext := func(e *core.RecordRequestEvent) error {
+		e.Record.Set(orderStatusAuthKey, e.Auth)
+		if info, err := e.RequestInfo(); err == nil {
+			if reason, ok := info.Body[orderStatusReasonField].(string); ok {
+				e.Record.Set(orderStatusReasonKey, strings.TrimSpace(reason))
+			}
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("orders").BindFunc(passStatusChangeContext)
+	app.OnRecordUpdateRequest("orders").BindFunc(passStatusChangeContext)
+
+	// the history row is written in the same transaction as the order. e.App is only swapped
+	// for it and put back afterwards, so the after-success hooks (status emails) don't get the
+	// committed transaction
+	app.OnRecordCreate("orders").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("orderStatus") == "" {
+			e.Record.Set("orderStatus", "draft")
+		}
+
+		originalApp := e.App
+		txErr := e.App.RunInTransaction(func(txApp core.App) error {
+			e.App = txApp
+
+			if err := e.Next(); err != nil {
+				return err
+			}
+			return saveOrderStatusHistory(txApp, e.Record, "", e.Record.GetString("orderStatus"))
+		})
+		e.App = originalApp
+
+		return txErr
+	})
+
+	app.OnRecordUpdate("orders").BindFunc(func(e *core.RecordEvent) error {
+		originalApp := e.App
+		txErr := e.App.RunInTransaction(func(txApp core.App) error {
+			e.App = txApp
+
+			// read from the db rather than Original(), which is stale when a record is saved twice
+			from, err := storedOrderStatus(txApp, e.Record.Id)
+			if err != nil {
+				return err
+			}
+			to := e.Record.GetString("orderStatus")
+			if from == to {
+				return e.Next()
+			}
+			steps := orderStatusSteps(e.Record, to)
+			previous := from
+			for _, step := range steps {
+				if err := validateOrderStatusTransition(previous, step); err != nil {
+					return err
+				}
+				previous = step
+			}
+
+			if err := e.Next(); err != nil {
+				return err
+			}
+
+			previous = from
+			for _, step := range steps {
+				if err := saveOrderStatusHistory(txApp, e.Record, previous, step); err != nil {
+					return err
+				}
+				previous = step
+			}
+			return nil
+		})
+		e.App = originalApp
+
+		return txErr
+	})
+}
+
+// orderStatusSteps is the run of statuses a save moves the order through: the steps set with
+// orderStatusStepsKey when they end at to, otherwise just to. The steps are used up.
+func orderStatusSteps(order *core.Record, to string) []string {
+	steps, _ := order.Get(orderStatusStepsKey).([]string)
+	order.Set(orderStatusStepsKey, nil)
+
+	if len(steps) == 0 || steps[len(steps)-1] != to {
+		return []string{to}
+	}
+	return steps
+}
+
+func storedOrderStatus(app core.App, orderId string) (string, error) {
+	var result struct {
+		Status string `db:"orderStatus"`
+	}
+	err := app.DB().
+		Select("orderStatus").
+		From("orders").
+		Where(dbx.HashExp{"id": orderId}).
+		One(&result)
+	return result.Status, err
+}
+
+// validateOrderStatusTransition checks from -> to against orderStatusTransitions. Orders saved
+// before statuses were enforced may have none, which counts as draft.
+func validateOrderStatusTransition(from, to string) error {
+	if from == to {
+		return nil
+	}
+	from = firstNonEmpty(from, "draft")
+
+	if to == "" {
+		return orderStatusError("validation_required", "Order status can't be cleared.")
+	}
+	if from == to {
+		return nil
+	}
+
+	allowed := orderStatusTransitions[from]
+	if slices.Contains(allowed, to) {
+		return nil
+	}
+
+	if len(allowed) == 0 {
+		return orderStatusError(
+			"validation_invalid_status_transition",
+			fmt.Sprintf("Cannot change order status from %s: it is final.", orderStatusLabel(from)),
+		)
+	}
+
+	labels := make([]string, 0, len(allowed))
+	for _, status := range allowed {
+		labels = append(labels, orderStatusLabel(status))
+	}
+	return orderStatusError(
+		"validation_invalid_status_transition",
+		fmt.Sprintf(
+			"Cannot change order status from %s to %s. Allowed next: %s.",
+			orderStatusLabel(from),
+			orderStatusLabel(to),
+			strings.Join(labels, ", "),
+		),
+	)
+}
+
+func orderStatusError(code, message string) error {
+	return validation.Errors{"orderStatus": validation.NewError(code, message)}
+}
+
+func orderStatusLabel(status string) string {
+	return firstNonEmpty(orderStatusLabels[status], status)
+}
+
+func saveOrderStatusHistory(app core.App, order *core.Record, from, to string) error {
+	coll, err := app.FindCollectionByNameOrId("order_status_history")
+	if err != nil {
+		return err
+	}
+
+	entry := core.NewRecord(coll)
+	entry.Set("orderId", order.Id)
+	entry.Set("fromStatus", from)
+	entry.Set("toStatus", to)
+	entry.Set("changedAt", types.NowDateTime())
+
+	if auth, ok := order.Get(orderStatusAuthKey).(*core.Record); ok && auth != nil {
+		if auth.Collection().Name == "users" {
+			entry.Set("changedBy", auth.Id)
+		}
+		entry.Set("changedByEmail", auth.Email())
+	}
+	if reason, ok := order.Get(orderStatusReasonKey).(string); ok {
+		entry.Set("reason", reason)
+	}
+
+	return app.Save(entry)
+}
+
+// orderStatusPath is the shortest run of allowed transitions from -> to, not counting from. It
+// is nil when to can't be reached.
+func orderStatusPath(from, to string) []string {
+	from = firstNonEmpty(from, "draft")
+	if from == to {
+		return nil
+	}
+
+	previous := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		status := queue[0]
+		queue = queue[1:]
+
+		for _, next := range orderStatusTransitions[status] {
+			if _, seen := previous[next]; seen {
+				continue
+			}
+			previous[next] = status
+			if next != to {
+				queue = append(queue, next)
+				continue
+			}
+
+			path := []string{to}
+			for step := status; step != from; step = previous[step] {
+				path = append([]string{step}, path...)
+			}
+			return path
+		}
+	}
+	return nil
+}
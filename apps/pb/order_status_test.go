@@ -0,0 +1,235 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+const testStatusOrderId = "order0000000002"
+
+func findStatusHistory(t testing.TB, app core.App, orderId string) []*core.Record {
+	history, err := app.FindRecordsByFilter(
+		"order_status_history",
+		"orderId = {:orderId}",
+		"changedAt,created",
+		0,
+		0,
+		dbx.Params{"orderId": orderId},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return history
+}
+
+func createTestCustomer(t testing.TB, app core.App, orderId string, email string) *core.Record {
+	coll, err := app.FindCollectionByNameOrId("customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer := core.NewRecord(coll)
+	customer.Set("firstName", "Jane")
+	customer.Set("surname", "Doe")
+	customer.Set("email", email)
+	customer.Set("orderId", orderId)
+	if err := app.Save(customer); err != nil {
+		t.Fatal(err)
+	}
+	return customer
+}
+
+func setOrderStatus(app core.App, order *core.Record, status string) error {
+	order.Set("orderStatus", status)
+	return app.Save(order)
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+	app := newTestApp(t, registerOrderStatusHooks)
+
+	order := createTestOrder(t, app, 0)
+	if order.GetString("orderStatus") != "draft" {
+		t.Fatalf("expected new orders to start as draft, got %q", order.GetString("orderStatus"))
+	}
+
+	for _, status := range []string{"in_progress", "ready", "delivered"} {
+		if err := setOrderStatus(app, order, status); err != nil {
+			t.Fatalf("expected %s to be allowed, got %v", status, err)
+		}
+	}
+
+	// delivered is final
+	if err := setOrderStatus(app, order, "in_progress"); err == nil {
+		t.Fatal("expected delivered -> in_progress to be rejected")
+	}
+	fresh, err := app.FindRecordById("orders", order.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.GetString("orderStatus") != "delivered" {
+		t.Errorf("expected the rejected change not to be saved, got %q", fresh.GetString("orderStatus"))
+	}
+
+	history := findStatusHistory(t, app, order.Id)
+	expected := [][2]string{{"", "draft"}, {"draft", "in_progress"}, {"in_progress", "ready"}, {"ready", "delivered"}}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d history rows, got %d", len(expected), len(history))
+	}
+	for i, entry := range history {
+		if entry.GetString("fromStatus") != expected[i][0] || entry.GetString("toStatus") != expected[i][1] {
+			t.Errorf("row %d: expected %v, got %s -> %s", i, expected[i], entry.GetString("fromStatus"), entry.GetString("toStatus"))
+		}
+		if entry.GetString("changedBy") != "" || entry.GetString("changedByEmail") != "" {
+			t.Errorf("row %d: expected no author for a change made by the server", i)
+		}
+	}
+}
+
+func TestOrderStatusChangeQueuesStatusEmail(t *testing.T) {
+	app := newTestApp(t, registerOrderStatusHooks, registerStatusEmailHooks)
+
+	order := createTestOrder(t, app, 0)
+	createTestCustomer(t, app, order.Id, "jane@example.com")
+
+	for _, status := range []string{"in_progress", "ready"} {
+		// a fresh copy each time, the way an API update loads it
+		fresh, err := app.FindRecordById("orders", order.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := setOrderStatus(app, fresh, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the email hooks run after the history transaction, on an app they can still read from
+	logRec := findOnlyEmailLog(t, app, "status_update")
+	if logRec.GetString("eventType") != "order_ready" || logRec.GetString("eventNote") != "In progress -> Ready" {
+		t.Errorf("expected the ready email, got %q (%q)", logRec.GetString("eventType"), logRec.GetString("eventNote"))
+	}
+	assertEmailLog(t, logRec, "queued", "queued")
+	assertOutboxCount(t, app, 1)
+}
+
+func TestValidateOrderStatusTransition(t *testing.T) {
+	scenarios := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{"draft", "in_progress", true},
+		{"draft", "delivered", false},
+		{"", "in_progress", true},
+		{"", "draft", true},
+		{"", "ready", false},
+		{"in_progress", "draft", true},
+		{"ready", "delivered", true},
+		{"delivered", "cancelled", false},
+		{"cancelled", "draft", true},
+		{"cancelled", "in_progress", false},
+		{"ready", "", false},
+		{"ready", "ready", true},
+	}
+
+	for _, s := range scenarios {
+		err := validateOrderStatusTransition(s.from, s.to)
+		if s.allowed && err != nil {
+			t.Errorf("%q -> %q: expected allowed, got %v", s.from, s.to, err)
+		}
+		if !s.allowed && err == nil {
+			t.Errorf("%q -> %q: expected an error", s.from, s.to)
+		}
+	}
+}
+
+func TestOrderStatusApi(t *testing.T) {
+	createOrder := func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+		registerOrderStatusHooks(&pocketbase.PocketBase{App: app})
+
+		coll, err := app.FindCollectionByNameOrId("orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		order := core.NewRecord(coll)
+		order.Id = testStatusOrderId
+		order.Set("orderNo", 77)
+		if err := app.Save(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "illegal transition",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/orders/records/" + testStatusOrderId,
+			Body:            strings.NewReader(`{"orderStatus": "delivered"}`),
+			Headers:         userAuthHeader(t),
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  createOrder,
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"orderStatus":{"code":"validation_invalid_status_transition"`, `Cannot change order status from Draft to Delivered. Allowed next: In progress, Cancelled.`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				if history := findStatusHistory(t, app, testStatusOrderId); len(history) != 1 {
+					t.Errorf("expected only the initial history row, got %d", len(history))
+				}
+			},
+		},
+		{
+			Name:               "allowed transition records user and reason",
+			Method:             http.MethodPatch,
+			URL:                "/api/collections/orders/records/" + testStatusOrderId,
+			Body:               strings.NewReader(`{"orderStatus": "in_progress", "statusReason": "Flowers arrived"}`),
+			Headers:            userAuthHeader(t),
+			TestAppFactory:     newEmailTestApp,
+			BeforeTestFunc:     createOrder,
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"orderStatus":"in_progress"`},
+			NotExpectedContent: []string{"statusReason", orderStatusAuthKey},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				history := findStatusHistory(t, app, testStatusOrderId)
+				if len(history) != 2 {
+					t.Fatalf("expected 2 history rows, got %d", len(history))
+				}
+				entry := history[1]
+				if entry.GetString("fromStatus") != "draft" || entry.GetString("toStatus") != "in_progress" {
+					t.Errorf("expected draft -> in_progress, got %s -> %s", entry.GetString("fromStatus"), entry.GetString("toStatus"))
+				}
+				if entry.GetString("reason") != "Flowers arrived" {
+					t.Errorf("expected the reason to be kept, got %q", entry.GetString("reason"))
+				}
+				if entry.GetString("changedBy") == "" || entry.GetString("changedByEmail") != "test@example.com" {
+					t.Errorf("expected the user to be recorded, got %q / %q", entry.GetString("changedBy"), entry.GetString("changedByEmail"))
+				}
+			},
+		},
+		{
+			Name:            "superuser is recorded by email",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/orders/records/" + testStatusOrderId,
+			Body:            strings.NewReader(`{"orderStatus": "cancelled"}`),
+			Headers:         superuserAuthHeader(t),
+			TestAppFactory:  newEmailTestApp,
+			BeforeTestFunc:  createOrder,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"orderStatus":"cancelled"`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				history := findStatusHistory(t, app, testStatusOrderId)
+				if len(history) != 2 {
+					t.Fatalf("expected 2 history rows, got %d", len(history))
+				}
+				if history[1].GetString("changedBy") != "" || history[1].GetString("changedByEmail") != "test@example.com" {
+					t.Errorf("expected only the superuser email, got %q / %q", history[1].GetString("changedBy"), history[1].GetString("changedByEmail"))
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+const defaultPaymentReminderCron = "0 9 * * *"
+
+// paymentReminderStage chases one waiting_* payment_status once the order is Days past its anchor
+// ("created" or "occasionDate"; orders without an occasion date fall back to created).
+type paymentReminderStage struct {
+	Key    string
+	Status string
+	Label  string
+	Anchor string
+	Days   int
+}
+
+var defaultPaymentReminderStages = []paymentReminderStage{
+	{Key: "first_deposit", Status: "waiting_first_deposit", Label: "first deposit", Anchor: "created", Days: 7},
+	{Key: "second_deposit", Status: "waiting_second_deposit", Label: "second deposit", Anchor: "occasionDate", Days: 28},
+	{Key: "final_balance", Status: "waiting_final_balance", Label: "final balance", Anchor: "occasionDate", Days: 56},
+}
+
+// emailPaymentReminder is the reminder part of the view model for email.payment_reminder.
+type emailPaymentReminder struct {
+	Stage      string
+	StageLabel string
+	AmountDue  string
+}
+
+// resolvePaymentReminderStages applies PAYMENT_REMINDER_<STAGE>_DAYS overrides (eg
+// PAYMENT_REMINDER_FINAL_BALANCE_DAYS=42). "off" disables a stage.
+func resolvePaymentReminderStages() []paymentReminderStage {
+	stages := make([]paymentReminderStage, 0, len(defaultPaymentReminderStages))
+	for _, stage := range defaultPaymentReminderStages {
+		raw := strings.TrimSpace(os.Getenv("PAYMENT_REMINDER_" + strings.ToUpper(stage.Key) + "_DAYS"))
+		switch {
+		case raw == "":
+		case strings.EqualFold(raw, "off"):
+			continue
+		default:
+			days, err := strconv.Atoi(raw)
+			if err != nil {
+				fmt.Println("invalid reminder threshold, using default:", stage.Key, raw)
+			} else {
+				stage.Days = days
+			}
+		}
+		stages = append(stages, stage)
+	}
+	return stages
+}
+
+func paymentReminderEventType(stage paymentReminderStage) string {
+	return "payment_reminder_" + stage.Key
+}
+
+func registerPaymentReminderJob(app *pocketbase.PocketBase) {
+	schedule := firstNonEmpty(strings.TrimSpace(os.Getenv("PAYMENT_REMINDER_CRON")), defaultPaymentReminderCron)
+	if strings.EqualFold(schedule, "off") {
+		return
+	}
+
+	app.Cron().MustAdd("paymentReminders", schedule, func() {
+		previewTemplatePath := resolvePathFromExecutable("pb_hooks", "views", "invoice.preview.html")
+		queued, err := runPaymentReminders(app, previewTemplatePath, time.Now())
+		if err != nil {
+			app.Logger().Error("payment reminders failed", "error", err.Error())
+			return
+		}
+		if queued > 0 {
+			app.Logger().Info("payment reminders queued", "count", queued)
+		}
+	})
+}
+
+// paymentReminderDue reports whether the order has been waiting past the stage threshold.
+func paymentReminderDue(order *core.Record, stage paymentReminderStage, now time.Time) bool {
+	anchor := order.GetDateTime("created").Time()
+	if stage.Anchor == "occasionDate" {
+		if occasion := order.GetDateTime("occasionDate"); !occasion.IsZero() {
+			anchor = occasion.Time()
+		}
+	}
+	if anchor.IsZero() {
+		return false
+	}
+	return !now.Before(anchor.AddDate(0, 0, stage.Days))
+}
+
+func paymentReminderAlreadySent(app core.App, orderId string, stage paymentReminderStage, channel string) (bool, error) {
+	logs, err := app.FindRecordsByFilter(
+		"email_logs",
+		`orderId = {:orderId} && emailType = "payment_reminder" && eventType = {:eventType} && channel = {:channel} && status != "failed"`,
+		"",
+		1,
+		0,
+		dbx.Params{"orderId": orderId, "eventType": paymentReminderEventType(stage), "channel": channel},
+	)
+	if err != nil {
+		return false, err
+	}
+	return len(logs) > 0, nil
+}
+
+// runPaymentReminders queues one reminder per order and stage (plus a text for customers who
+// opted in), returning how many were queued or sent.
+func runPaymentReminders(app core.App, previewTemplatePath string, now time.Time) (int, error) {
+	queued := 0
+
+	for _, stage := range resolvePaymentReminderStages() {
+		orders, err := app.FindRecordsByFilter(
+			"orders",
+			`payment_status = {:status} && orderStatus != "cancelled" && orderStatus != "draft"`,
+			"created",
+			0,
+			0,
+			dbx.Params{"status": stage.Status},
+		)
+		if err != nil {
+			return queued, err
+		}
+
+		for _, order := range orders {
+			if !paymentReminderDue(order, stage, now) {
+				continue
+			}
+
+			sent, err := paymentReminderAlreadySent(app, order.Id, stage, "email")
+			if err != nil {
+				return queued, err
+			}
+			if !sent {
+				ok, err := queuePaymentReminder(app, previewTemplatePath, order.Id, stage)
+				if err != nil {
+					app.Logger().Error("payment reminder failed", "orderId", order.Id, "stage", stage.Key, "error", err.Error())
+				} else if ok {
+					queued++
+				}
+			}
+
+			if currentSmsSender() == nil {
+				continue
+			}
+			sent, err = paymentReminderAlreadySent(app, order.Id, stage, "sms")
+			if err != nil {
+				return queued, err
+			}
+			if !sent {
+				ok, err := sendPaymentReminderSms(app, order.Id, stage)
+				if err != nil {
+					app.Logger().Error("payment reminder sms failed", "orderId", order.Id, "stage", stage.Key, "error", err.Error())
+				} else if ok {
+					queued++
+				}
+			}
+		}
+	}
+
+	return queued, nil
+}
+
+// queuePaymentReminder renders email.payment_reminder with the current invoice attached.
+// The email log is written first: it claims the stage, so a duplicate run fails on the index.
+func queuePaymentReminder(app core.App, previewTemplatePath string, orderId string, stage paymentReminderStage) (bool, error) {
+	src, err := loadOrderInvoiceSource(app, orderId)
+	if err != nil {
+		return false, err
+	}
+
+	payload := src.toInvoicePayload()
+	if strings.TrimSpace(payload.Customer.Email) == "" {
+		return false, nil
+	}
+
+	logCtx, meta := buildEmailLogContextFromPayload(payload, "payment_reminder", paymentReminderEventType(stage), "email.payment_reminder")
+	logCtx.EventNote = fmt.Sprintf("%s, %d days after %s", stage.Status, stage.Days, stage.Anchor)
+	meta["source"] = "payment_reminder_job"
+
+	subject := fmt.Sprintf("Payment reminder: %s", stage.Label)
+	logRec, err := createEmailLog(app, nil, payload.Customer.Email, buildCustomerDisplayName(payload), subject, logCtx, meta)
+	if err != nil {
+		return false, fmt.Errorf("claim reminder stage: %w", err)
+	}
+
+	fail := func(stageName string, err error) (bool, error) {
+		updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{"stage": stageName})
+		return false, err
+	}
+
+	payments, err := loadInvoicePayments(app, src.Order.Id)
+	if err != nil {
+		return fail("load_payments", err)
+	}
+	invoice := buildInvoiceViewModel(payload, payments)
+
+	// the stored invoice is re-sent while it still shows today's balance; one that pre-dates a
+	// payment or a price change is re-issued so the attachment matches the reminder
+	doc, err := resolveInvoiceDocument(app, nil, payload, previewTemplatePath, false)
+	if err == nil && doc.Reused && !sameInvoiceFigures(doc.Invoice, invoice) {
+		doc, err = resolveInvoiceDocument(app, nil, payload, previewTemplatePath, true)
+	}
+	if err != nil {
+		stageName := "render_html"
+		if stageErr, ok := err.(*invoiceStageError); ok {
+			stageName = stageErr.Stage
+		}
+		return fail(stageName, err)
+	}
+	linkInvoiceEmailLog(app, doc.Record, logRec)
+	invoice.InvoiceNo = doc.Invoice.InvoiceNo
+
+	view := buildEmailViewModel(payload)
+	view.Invoice = &invoice
+	view.Reminder = &emailPaymentReminder{
+		Stage:      stage.Key,
+		StageLabel: stage.Label,
+		AmountDue:  invoice.BalanceDue,
+	}
+
+	rendered, err := renderEmailTemplate(app, resolvePathFromExecutable("pb_hooks", "views"), logCtx.TemplateKey, "", view)
+	if err != nil {
+		return fail("render_email", err)
+	}
+	recordRenderedEmail(logRec, rendered)
+
+	msg := &mailer.Message{
+		From: mail.Address{
+			Address: app.Settings().Meta.SenderAddress,
+			Name:    app.Settings().Meta.SenderName,
+		},
+		To:      []mail.Address{{Address: payload.Customer.Email}},
+		Subject: firstNonEmpty(rendered.Subject, subject),
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+		Attachments: map[string]io.Reader{
+			"invoice.pdf": bytes.NewReader(doc.PDF),
+		},
+	}
+
+	invoiceMeta := map[string]any{"invoiceReused": doc.Reused, "pdfBytes": len(doc.PDF)}
+	if doc.Record != nil {
+		invoiceMeta["invoiceId"] = doc.Record.Id
+		invoiceMeta["invoiceVersion"] = doc.Record.GetInt("version")
+	}
+
+	if _, err := queueEmailForLog(app, logRec, msg, invoiceMeta); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sendPaymentReminderSms texts the reminder with today's balance. Like the email, the log row
+// claims the stage first.
+func sendPaymentReminderSms(app core.App, orderId string, stage paymentReminderStage) (bool, error) {
+	src, err := loadOrderInvoiceSource(app, orderId)
+	if err != nil {
+		return false, err
+	}
+
+	invoice, err := buildOrderInvoiceView(app, src)
+	if err != nil {
+		return false, err
+	}
+
+	payload := src.toInvoicePayload()
+	view := buildEmailViewModel(payload)
+	view.Invoice = &invoice
+	view.Reminder = &emailPaymentReminder{
+		Stage:      stage.Key,
+		StageLabel: stage.Label,
+		AmountDue:  invoice.BalanceDue,
+	}
+
+	logCtx, meta := buildEmailLogContextFromPayload(payload, "payment_reminder", paymentReminderEventType(stage), "sms.payment_reminder")
+	logCtx.EventNote = fmt.Sprintf("%s, %d days after %s", stage.Status, stage.Days, stage.Anchor)
+	meta["source"] = "payment_reminder_job"
+
+	return sendOrderSms(app, src, view, logCtx, meta)
+}
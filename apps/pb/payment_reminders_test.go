@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func createTestPayment(t testing.TB, app core.App, orderId string, amount float64) *core.Record {
+	coll, err := app.FindCollectionByNameOrId("payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := core.NewRecord(coll)
+	payment.Set("orderId", orderId)
+	payment.Set("amount", amount)
+	payment.Set("method", "card")
+	payment.Set("kind", "first_deposit")
+	payment.Set("paidAt", "2026-05-01 10:00:00.000Z")
+	if err := app.Save(payment); err != nil {
+		t.Fatal(err)
+	}
+	return payment
+}
+
+func TestPaymentReminderAttachesCurrentInvoice(t *testing.T) {
+	app := newTestApp(t)
+
+	frame := createTestFrame(t, app, map[string]any{"price": 250})
+	order := createTestOrderWithFrames(t, app, frame)
+	createTestCustomer(t, app, order.Id, "jane@example.com")
+
+	remind := func(stage paymentReminderStage) *core.Record {
+		t.Helper()
+
+		if queued, err := queuePaymentReminder(app, testPreviewTemplatePath, order.Id, stage); err != nil || !queued {
+			t.Fatalf("expected the %s reminder to be queued, got %v", stage.Key, err)
+		}
+		latest, err := findLatestIssuedInvoice(app, order.Id)
+		if err != nil || latest == nil {
+			t.Fatalf("expected an issued invoice, got %v", err)
+		}
+		return latest
+	}
+
+	first := remind(defaultPaymentReminderStages[0])
+
+	// nothing changed, so the same invoice goes out again
+	if again := remind(defaultPaymentReminderStages[1]); again.Id != first.Id {
+		t.Errorf("expected invoice v%d to be re-sent, got v%d", first.GetInt("version"), again.GetInt("version"))
+	}
+
+	// a payment since changes the balance, so the reminder carries a new version
+	createTestPayment(t, app, order.Id, 100)
+	latest := remind(defaultPaymentReminderStages[2])
+	if latest.Id == first.Id {
+		t.Fatal("expected a new invoice version after the payment")
+	}
+	var view invoiceViewModel
+	if err := latest.UnmarshalJSONField("snapshot", &view); err != nil {
+		t.Fatal(err)
+	}
+	if view.Credits != formatMoney(100) {
+		t.Errorf("expected the new version to show the payment, got credits %q", view.Credits)
+	}
+}
+
+func TestFirstDepositLeadsToSecondDepositReminder(t *testing.T) {
+	app := newTestApp(t, registerPaymentHooks)
+
+	frame := createTestFrame(t, app, map[string]any{"price": 250})
+	order := createTestOrderWithFrames(t, app, frame)
+	order.Set("orderStatus", "in_progress")
+	order.Set("occasionDate", "2026-06-01 00:00:00.000Z")
+	if err := app.Save(order); err != nil {
+		t.Fatal(err)
+	}
+	createTestCustomer(t, app, order.Id, "jane@example.com")
+
+	createTestPayment(t, app, order.Id, 100)
+
+	now, _ := time.Parse("2006-01-02", "2026-06-29")
+	queued, err := runPaymentReminders(app, testPreviewTemplatePath, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued != 1 {
+		t.Fatalf("expected 1 reminder, got %d", queued)
+	}
+
+	logRec := findOnlyEmailLog(t, app, "payment_reminder")
+	if got := logRec.GetString("eventType"); got != "payment_reminder_second_deposit" {
+		t.Errorf("expected the second deposit reminder, got %q", got)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// payment_status only ever moves forward through this sequence.
+var paymentStatusRank = map[string]int{
+	"waiting_first_deposit":  0,
+	"first_deposit_paid":     1,
+	"waiting_second_deposit": 2,
+	"second_deposit_paid":    3,
+	"waiting_final_balance":  4,
+	"final_balance_paid":     5,
+}
+
+// paymentStatusPaid is the payment each status says has been received, so a deleted payment
+// can tell which statuses no longer hold.
+var paymentStatusPaid = map[string]string{
+	"first_deposit_paid":     "first_deposit_paid",
+	"waiting_second_deposit": "first_deposit_paid",
+	"second_deposit_paid":    "second_deposit_paid",
+	"waiting_final_balance":  "second_deposit_paid",
+	"final_balance_paid":     "final_balance_paid",
+}
+
+var paymentMethodLabels = map[string]string{
+	"bank_transfer": "Bank transfer",
+	"card":          "Card",
+	"cash":          "Cash",
+	"cheque":        "Cheque",
+	"other":         "Other",
+}
+
+var paymentKindLabels = map[string]string{
+	"first_deposit":  "First deposit",
+	"second_deposit": "Second deposit",
+	"final_balance":  "Final balance",
+	"other":          "Payment",
+}
+
+type invoicePayment struct {
+	Kind   string
+	Method string
+	PaidAt string // YYYY-MM-DD
+	Amount float64
+}
+
+type invoicePaymentRow struct {
+	Description string
+	Amount      string
+}
+
+func registerPaymentHooks(app *pocketbase.PocketBase) {
+	app.OnRecordCreateRequest("payments").BindFunc(func(e *core.RecordRequestEvent) error {
+		if e.Auth != nil && e.Auth.Collection().Name == "users" && e.Record.GetString("recordedBy") == "" {
+			e.Record.Set("recordedBy", e.Auth.Id)
+		}
+		return e.Next()
+	})
+
+	sync := func(e *core.RecordEvent) error {
+		if err := syncOrderPaymentStatus(e.App, e.Record.GetString("orderId")); err != nil {
+			e.App.Logger().Error("payment status sync failed", "orderId", e.Record.GetString("orderId"), "error", err.Error())
+		}
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess("payments").BindFunc(sync)
+	app.OnRecordAfterUpdateSuccess("payments").BindFunc(sync)
+
+	// deleting a payment is the one way back, e.g. a deposit recorded on the wrong order
+	app.OnRecordAfterDeleteSuccess("payments").BindFunc(func(e *core.RecordEvent) error {
+		err := rollBackOrderPaymentStatus(e.App, e.Record.GetString("orderId"))
+		// the order itself may be what was deleted
+		if err != nil && !errors.Is(err, errOrderNotFound) {
+			e.App.Logger().Error("payment status rollback failed", "orderId", e.Record.GetString("orderId"), "error", err.Error())
+		}
+		return e.Next()
+	})
+}
+
+func loadOrderPayments(app core.App, orderId string) ([]*core.Record, error) {
+	if strings.TrimSpace(orderId) == "" {
+		return []*core.Record{}, nil
+	}
+	return app.FindRecordsByFilter(
+		"payments",
+		"orderId = {:orderId}",
+		"paidAt",
+		0,
+		0,
+		dbx.Params{"orderId": orderId},
+	)
+}
+
+// loadInvoicePayments returns the payments received for an order, oldest first.
+func loadInvoicePayments(app core.App, orderId string) ([]invoicePayment, error) {
+	records, err := loadOrderPayments(app, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := make([]invoicePayment, 0, len(records))
+	for _, rec := range records {
+		payments = append(payments, invoicePayment{
+			Kind:   rec.GetString("kind"),
+			Method: rec.GetString("method"),
+			PaidAt: recordDate(rec.GetString("paidAt")),
+			Amount: rec.GetFloat("amount"),
+		})
+	}
+	return payments, nil
+}
+
+func sumPayments(payments []invoicePayment) float64 {
+	total := 0.0
+	for _, p := range payments {
+		total += p.Amount
+	}
+	return roundMoney(total)
+}
+
+func buildInvoicePaymentRows(payments []invoicePayment) []invoicePaymentRow {
+	rows := make([]invoicePaymentRow, 0, len(payments))
+	for _, p := range payments {
+		desc := firstNonEmpty(paymentKindLabels[p.Kind], "Payment")
+		if label := paymentMethodLabels[p.Method]; label != "" {
+			desc = fmt.Sprintf("%s (%s)", desc, label)
+		}
+		if p.PaidAt != "" {
+			desc = fmt.Sprintf("%s - %s", desc, formatDate(p.PaidAt))
+		}
+		rows = append(rows, invoicePaymentRow{
+			Description: desc,
+			Amount:      formatMoney(p.Amount),
+		})
+	}
+	return rows
+}
+
+// resolvePaymentStatus works out where the order should be given what has been received. A paid
+// deposit moves the order straight on to waiting for the next payment, which is what the
+// payment reminders chase. Returns "" when the payments don't justify any particular status.
+func resolvePaymentStatus(payments []invoicePayment, grandTotal float64) string {
+	hasKind := map[string]bool{}
+	for _, p := range payments {
+		hasKind[p.Kind] = true
+	}
+
+	switch {
+	case grandTotal > 0 && sumPayments(payments)+totalsTolerance >= grandTotal:
+		return "final_balance_paid"
+	case hasKind["second_deposit"]:
+		return "waiting_final_balance"
+	case hasKind["first_deposit"]:
+		return "waiting_second_deposit"
+	default:
+		return ""
+	}
+}
+
+// syncOrderPaymentStatus moves orders.payment_status forward (never back) as payments arrive.
+func syncOrderPaymentStatus(app core.App, orderId string) error {
+	src, err := loadOrderInvoiceSource(app, orderId)
+	if err != nil {
+		return err
+	}
+
+	payments, err := loadInvoicePayments(app, src.Order.Id)
+	if err != nil {
+		return err
+	}
+
+	totals := computeInvoiceTotals(src.toInvoicePayload())
+	next := resolvePaymentStatus(payments, totals.GrandTotal)
+	if next == "" {
+		return nil
+	}
+
+	current := src.Order.GetString("payment_status")
+	if currentRank, ok := paymentStatusRank[current]; ok && currentRank >= paymentStatusRank[next] {
+		return nil
+	}
+
+	src.Order.Set("payment_status", next)
+	return app.Save(src.Order)
+}
+
+// rollBackOrderPaymentStatus moves orders.payment_status back to what the remaining payments
+// justify, once one is deleted. A status the payments still support is left alone.
+func rollBackOrderPaymentStatus(app core.App, orderId string) error {
+	src, err := loadOrderInvoiceSource(app, orderId)
+	if err != nil {
+		return err
+	}
+
+	current := src.Order.GetString("payment_status")
+	paid, ok := paymentStatusPaid[current]
+	if !ok {
+		return nil
+	}
+
+	payments, err := loadInvoicePayments(app, src.Order.Id)
+	if err != nil {
+		return err
+	}
+
+	totals := computeInvoiceTotals(src.toInvoicePayload())
+	next := firstNonEmpty(resolvePaymentStatus(payments, totals.GrandTotal), "waiting_first_deposit")
+	if paymentStatusRank[next] >= paymentStatusRank[paid] {
+		return nil
+	}
+
+	src.Order.Set("payment_status", next)
+	return app.Save(src.Order)
+}
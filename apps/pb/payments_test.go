@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestPaymentStatusFollowsDeletedPayments(t *testing.T) {
+	app := newTestApp(t, registerPaymentHooks)
+
+	frame := createTestFrame(t, app, map[string]any{"price": 250})
+	order := createTestOrderWithFrames(t, app, frame)
+
+	assertStatus := func(expected string) {
+		t.Helper()
+		fresh, err := app.FindRecordById("orders", order.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fresh.GetString("payment_status"); got != expected {
+			t.Fatalf("expected payment_status %q, got %q", expected, got)
+		}
+	}
+	deletePayment := func(payment *core.Record) {
+		t.Helper()
+		if err := app.Delete(payment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := createTestPayment(t, app, order.Id, 100)
+	assertStatus("waiting_second_deposit")
+
+	second := createTestPayment(t, app, order.Id, 50)
+	second.Set("kind", "second_deposit")
+	if err := app.Save(second); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus("waiting_final_balance")
+
+	deletePayment(second)
+	assertStatus("waiting_second_deposit")
+
+	// the first deposit still backs waiting on the second one
+	other := createTestPayment(t, app, order.Id, 10)
+	other.Set("kind", "other")
+	if err := app.Save(other); err != nil {
+		t.Fatal(err)
+	}
+	deletePayment(other)
+	assertStatus("waiting_second_deposit")
+
+	deletePayment(first)
+	assertStatus("waiting_first_deposit")
+}
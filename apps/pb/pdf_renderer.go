@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// pdfRenderer turns a rendered invoice into a PDF. Implementations may use the HTML
+// (external converters) or the view model directly (in-process layout).
+type pdfRenderer interface {
+	Name() string
+	Render(html string, view invoiceViewModel) ([]byte, error)
+}
+
+var (
+	pdfRendererOnce   sync.Once
+	activePdfRenderer pdfRenderer
+)
+
+// currentPdfRenderer resolves INVOICE_PDF_RENDERER once:
+//   - "wkhtmltopdf": shell out to INVOICE_PDF_BIN (default wkhtmltopdf)
+//   - "native": in-process renderer, no external binary
+//   - unset: wkhtmltopdf when the binary is on PATH, native otherwise (local dev)
+func currentPdfRenderer() pdfRenderer {
+	pdfRendererOnce.Do(func() {
+		if activePdfRenderer == nil {
+			activePdfRenderer = newPdfRendererFromEnv()
+		}
+	})
+	return activePdfRenderer
+}
+
+// setPdfRenderer overrides the configured renderer (tests, custom setups).
+func setPdfRenderer(renderer pdfRenderer) {
+	pdfRendererOnce.Do(func() {})
+	activePdfRenderer = renderer
+}
+
+func newPdfRendererFromEnv() pdfRenderer {
+	bin := strings.TrimSpace(os.Getenv("INVOICE_PDF_BIN"))
+	if bin == "" {
+		bin = "wkhtmltopdf"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("INVOICE_PDF_RENDERER"))) {
+	case "wkhtmltopdf":
+		return &wkhtmltopdfRenderer{bin: bin}
+	case "native":
+		return &nativePdfRenderer{compress: true}
+	}
+
+	if _, err := exec.LookPath(bin); err == nil {
+		return &wkhtmltopdfRenderer{bin: bin}
+	}
+	return &nativePdfRenderer{compress: true}
+}
+
+func renderInvoicePdf(html string, view invoiceViewModel) ([]byte, error) {
+	return currentPdfRenderer().Render(html, view)
+}
+
+//
+// -------- wkhtmltopdf --------
+//
+
+type wkhtmltopdfRenderer struct {
+	bin string
+}
+
+func (r *wkhtmltopdfRenderer) Name() string { return "wkhtmltopdf" }
+
+func (r *wkhtmltopdfRenderer) Render(html string, _ invoiceViewModel) ([]byte, error) {
+	tempDir := os.TempDir()
+
+	htmlFile, err := os.CreateTemp(tempDir, "invoice-*.html")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = htmlFile.Close() }()
+	defer os.Remove(htmlFile.Name())
+
+	if _, err := htmlFile.WriteString(html); err != nil {
+		return nil, err
+	}
+	if err := htmlFile.Close(); err != nil {
+		return nil, err
+	}
+
+	pdfPath := strings.TrimSuffix(htmlFile.Name(), ".html") + ".pdf"
+	defer os.Remove(pdfPath)
+
+	cmd := exec.Command(
+		r.bin,
+		"--enable-local-file-access",
+		"--print-media-type",
+		htmlFile.Name(),
+		pdfPath,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, err
+		}
+		return nil, fmt.Errorf("wkhtmltopdf failed: %w: %s", err, msg)
+	}
+
+	return os.ReadFile(pdfPath)
+}
+
+//
+// -------- native (in-process) --------
+//
+
+// nativePdfRenderer lays the invoice out directly from the view model, mirroring
+// invoice.preview.html. compress=false keeps text streams readable for assertions.
+type nativePdfRenderer struct {
+	compress bool
+}
+
+func (r *nativePdfRenderer) Name() string { return "native" }
+
+const (
+	pdfMarginX    = 20.0
+	pdfMarginTop  = 18.0
+	pdfPageWidth  = 210.0
+	pdfContentW   = pdfPageWidth - 2*pdfMarginX
+	pdfLineHeight = 5.5
+)
+
+var pdfFooterLines = []string{
+	"Precious Petals Limited, Unit 10 Cufaude Business Park, Cufaude Lane, Bramley, RG26 5DL. Telephone 01256 882422.",
+	"Our studio opening times are Monday to Thursday 9:00am to 4:00pm, plus Friday and Saturday 9:30am to 12:30 (by advance appointment only).",
+	"Company Reg.no: 04705425. VAT Reg no: 742539622.",
+}
+
+func (r *nativePdfRenderer) Render(_ string, view invoiceViewModel) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetCompression(r.compress)
+	// fixed dates and sorted font/image objects keep output byte-stable for the same invoice
+	pdf.SetCreationDate(time.Unix(0, 0).UTC())
+	pdf.SetModificationDate(time.Unix(0, 0).UTC())
+	pdf.SetCatalogSort(true)
+	pdf.SetMargins(pdfMarginX, pdfMarginTop, pdfMarginX)
+	pdf.SetAutoPageBreak(true, 30)
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-24)
+		pdf.SetFont("Times", "", 8)
+		pdf.SetTextColor(68, 68, 68)
+		for _, line := range pdfFooterLines {
+			pdf.CellFormat(0, 4, tr(line), "", 1, "C", false, 0, "")
+		}
+		pdf.SetTextColor(17, 17, 17)
+	})
+
+	pdf.AddPage()
+	pdf.SetTextColor(17, 17, 17)
+
+	// header: brand + address on the left, document title on the right
+	pdf.SetFont("Times", "B", 20)
+	pdf.CellFormat(pdfContentW-50, 10, tr("Precious Petals"), "", 0, "L", false, 0, "")
+	pdf.SetFont("Times", "B", 11)
+	pdf.CellFormat(50, 10, tr(firstNonEmpty(view.Title, "INVOICE")), "", 1, "R", false, 0, "")
+
+	pdf.SetFont("Times", "", 10)
+	pdf.MultiCell(pdfContentW-50, pdfLineHeight, tr(view.Address), "", "L", false)
+	pdf.Ln(6)
+
+	// meta row
+	dateLabel := "Invoice Date"
+	if view.IsCreditNote {
+		dateLabel = "Credit Note Date"
+	}
+	colW := pdfContentW / 3
+	pdf.SetFont("Times", "B", 10)
+	pdf.CellFormat(colW, pdfLineHeight, tr("Occasion Date: "+view.OccasionDate), "", 0, "L", false, 0, "")
+	pdf.CellFormat(colW, pdfLineHeight, tr(dateLabel+": "+view.InvoiceDate), "", 0, "L", false, 0, "")
+	pdf.CellFormat(colW, pdfLineHeight, tr(firstNonEmpty(view.DocumentNoLabel, "Invoice No")+": "+view.InvoiceNo), "", 1, "L", false, 0, "")
+
+	if view.IsCreditNote {
+		pdf.Ln(2)
+		pdf.SetFont("Times", "", 10)
+		pdf.CellFormat(0, pdfLineHeight, tr("Credit against invoice: "+view.CreditedInvoiceNo), "", 1, "L", false, 0, "")
+		if view.Reason != "" {
+			pdf.MultiCell(0, pdfLineHeight, tr("Reason: "+view.Reason), "", "L", false)
+		}
+	}
+	pdf.Ln(6)
+
+	// rows table
+	itemW, amountW := 22.0, 38.0
+	descW := pdfContentW - itemW - amountW
+
+	pdf.SetFont("Times", "B", 10)
+	pdf.CellFormat(itemW, 7, tr("Item"), "B", 0, "L", false, 0, "")
+	pdf.CellFormat(descW, 7, tr("Item"), "B", 0, "L", false, 0, "")
+	pdf.CellFormat(amountW, 7, tr("Amount (Inc VAT)"), "B", 1, "R", false, 0, "")
+	pdf.Ln(1)
+
+	for _, row := range view.Rows {
+		desc := row.Description
+		indent := 0.0
+		if row.IsSubItem {
+			indent = 4
+			pdf.SetTextColor(51, 51, 51)
+		}
+
+		pdf.SetFont("Times", "", 10)
+		lines := pdf.SplitLines([]byte(tr(desc)), descW-indent)
+		if len(lines) == 0 {
+			lines = [][]byte{{}}
+		}
+		rowH := float64(len(lines)) * pdfLineHeight
+
+		y := pdf.GetY()
+		pdf.SetFont("Times", "B", 10)
+		pdf.CellFormat(itemW, pdfLineHeight, tr(row.ItemLabel), "", 0, "L", false, 0, "")
+		pdf.SetFont("Times", "", 10)
+		pdf.SetXY(pdfMarginX+itemW+indent, y)
+		pdf.MultiCell(descW-indent, pdfLineHeight, tr(desc), "", "L", false)
+		pdf.SetXY(pdfMarginX+itemW+descW, y)
+		pdf.CellFormat(amountW, pdfLineHeight, tr(row.Amount), "", 0, "R", false, 0, "")
+		pdf.SetXY(pdfMarginX, y+rowH+1)
+
+		pdf.SetTextColor(17, 17, 17)
+	}
+	pdf.Ln(8)
+
+	// summary: bank details left, totals right
+	summaryY := pdf.GetY()
+	leftW := pdfContentW * 0.55
+	rightX := pdfMarginX + leftW + 8
+	rightW := pdfContentW - leftW - 8
+
+	pdf.SetFont("Times", "B", 10)
+	pdf.CellFormat(leftW, pdfLineHeight, tr("Bank Account Details"), "", 1, "L", false, 0, "")
+	pdf.SetFont("Times", "", 10)
+	pdf.CellFormat(leftW, pdfLineHeight, tr("Sort Code: 30-18-45 Account Number: 00968386"), "", 1, "L", false, 0, "")
+
+	pdf.SetY(summaryY)
+	totalsRow := func(label, value, style string) {
+		pdf.SetX(rightX)
+		pdf.SetFont("Times", style, 10)
+		pdf.CellFormat(rightW-30, pdfLineHeight+1, tr(label), "", 0, "L", false, 0, "")
+		pdf.CellFormat(30, pdfLineHeight+1, tr(value), "", 1, "R", false, 0, "")
+	}
+
+	totalLabel := "Total"
+	if view.IsCreditNote {
+		totalLabel = "Total Credited"
+	}
+	totalsRow("Sub Total (Ex VAT)", view.SubTotal, "")
+	totalsRow("VAT Total", view.VatTotal, "")
+	totalsRow(totalLabel, view.GrandTotal, "B")
+	if !view.IsCreditNote {
+		for _, p := range view.Payments {
+			totalsRow("  "+p.Description, p.Amount, "")
+		}
+		totalsRow("Credits", view.Credits, "")
+		pdf.Ln(2)
+		totalsRow("Balance Due", view.BalanceDue, "B")
+	}
+
+	// notes box under the totals
+	pdf.Ln(4)
+	pdf.SetX(rightX)
+	pdf.SetFont("Times", "B", 10)
+	pdf.CellFormat(rightW, pdfLineHeight, tr("Notes"), "", 1, "L", false, 0, "")
+	pdf.SetX(rightX)
+	pdf.SetFont("Times", "", 10)
+	notes := view.Notes
+	if strings.TrimSpace(notes) == "" {
+		notes = "\n\n\n"
+	}
+	pdf.MultiCell(rightW, pdfLineHeight, tr(notes), "1", "L", false)
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, fmt.Errorf("native pdf render failed: %w", err)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNativePdfRenderer(t *testing.T) {
+	view := invoiceViewModel{
+		Title:           "INVOICE",
+		DocumentNoLabel: "Invoice No",
+		Address:         "Jane Doe\n1 High Street",
+		OccasionDate:    "01/06/2026",
+		InvoiceDate:     "01/05/2026",
+		InvoiceNo:       "INV-0042",
+		Rows: []invoiceRow{
+			{ItemLabel: "Item 1", Description: "Oak frame 12x16 inches", Amount: formatMoney(250)},
+			{ItemLabel: "", Description: "Glass engraving", Amount: formatMoney(40), IsSubItem: true},
+		},
+		SubTotal:   formatMoney(241.67),
+		VatTotal:   formatMoney(48.33),
+		GrandTotal: formatMoney(290),
+		Payments:   []invoicePaymentRow{{Description: "Deposit (card)", Amount: formatMoney(100)}},
+		Credits:    formatMoney(100),
+		BalanceDue: formatMoney(190),
+	}
+
+	// uncompressed, so the text is readable in the content stream; the core fonts are cp1252,
+	// where the pound sign is 0xA3
+	pdf, err := (&nativePdfRenderer{compress: false}).Render("", view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Fatal("expected a pdf")
+	}
+
+	for _, expected := range []string{
+		"(INVOICE)",
+		"(Invoice No: INV-0042)",
+		"(Occasion Date: 01/06/2026)",
+		"(Item 1)",
+		"(Oak frame 12x16 inches)",
+		"(\xa3250.00)",
+		"(Glass engraving)",
+		"(\xa340.00)",
+		"(Sub Total \\(Ex VAT\\))",
+		"(\xa3241.67)",
+		"(VAT Total)",
+		"(\xa348.33)",
+		"(Total)",
+		"(\xa3290.00)",
+		"(  Deposit \\(card\\))",
+		"(Balance Due)",
+		"(\xa3190.00)",
+	} {
+		if !bytes.Contains(pdf, []byte(expected)) {
+			t.Errorf("expected %q in the pdf", expected)
+		}
+	}
+
+	// the same invoice renders to the same bytes, so the pdf cache and ETags hold
+	again, err := (&nativePdfRenderer{compress: false}).Render("", view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pdf, again) {
+		t.Error("expected byte-stable output")
+	}
+}
+
+func TestNativePdfRendererCreditNote(t *testing.T) {
+	view := invoiceViewModel{
+		Title:             "CREDIT NOTE",
+		DocumentNoLabel:   "Credit Note No",
+		IsCreditNote:      true,
+		CreditedInvoiceNo: "INV-0042",
+		Reason:            "Order cancelled",
+		InvoiceNo:         "CN-0001",
+		Rows:              []invoiceRow{{ItemLabel: "Credit 1", Description: "Refund", Amount: formatMoney(50)}},
+		GrandTotal:        formatMoney(60),
+		BalanceDue:        formatMoney(999),
+	}
+
+	pdf, err := (&nativePdfRenderer{compress: false}).Render("", view)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"(CREDIT NOTE)",
+		"(Credit Note No: CN-0001)",
+		"(Credit against invoice: INV-0042)",
+		"(Reason: Order cancelled)",
+		"(Total Credited)",
+		"(\xa360.00)",
+	} {
+		if !bytes.Contains(pdf, []byte(expected)) {
+			t.Errorf("expected %q in the pdf", expected)
+		}
+	}
+	if bytes.Contains(pdf, []byte("(Balance Due)")) {
+		t.Error("expected no balance on a credit note")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const defaultVatRate = 0.2
+
+// totals are compared to the penny; anything beyond that is treated as a real disagreement
+const totalsTolerance = 0.005
+
+type invoiceTotals struct {
+	SubTotal   float64
+	VatRate    float64
+	VatTotal   float64
+	GrandTotal float64
+}
+
+type pricingFrame struct {
+	Price               float64
+	MountPrice          float64
+	GlassPrice          float64
+	GlassEngravingPrice float64
+}
+
+// pricingInput is the flattened set of chargeable amounts for an order, ex VAT.
+// It can be built from a FE payload or from stored records so both paths price the same way.
+type pricingInput struct {
+	Frames           []pricingFrame
+	PaperweightPrice float64
+	ExtrasPrices     []float64
+}
+
+// resolveVatRate reads INVOICE_VAT_RATE (eg "0.2"), falling back to the UK standard rate.
+func resolveVatRate() float64 {
+	raw := strings.TrimSpace(os.Getenv("INVOICE_VAT_RATE"))
+	if raw == "" {
+		return defaultVatRate
+	}
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rate < 0 || rate >= 1 {
+		return defaultVatRate
+	}
+	return rate
+}
+
+func roundMoney(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+func floatOrZero(value *float64) float64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+func pricingInputFromPayload(payload invoicePayload) pricingInput {
+	input := pricingInput{}
+
+	for _, frame := range payload.Frames {
+		pf := pricingFrame{Price: floatOrZero(frame.Price.Float64())}
+		if frame.Extras != nil {
+			pf.MountPrice = floatOrZero(frame.Extras.MountPrice.Float64())
+			pf.GlassPrice = floatOrZero(frame.Extras.GlassPrice.Float64())
+			pf.GlassEngravingPrice = floatOrZero(frame.Extras.GlassEngravingPrice.Float64())
+		}
+		input.Frames = append(input.Frames, pf)
+	}
+
+	if pw := payload.GetPaperweight(); pw != nil {
+		input.PaperweightPrice = floatOrZero(pw.Price.Float64())
+	}
+
+	if extras := payload.OrderExtras; extras != nil {
+		input.ExtrasPrices = []float64{
+			floatOrZero(extras.ReplacementFlowersPrice.Float64()),
+			floatOrZero(extras.CollectionPrice.Float64()),
+			floatOrZero(extras.DeliveryPrice.Float64()),
+			floatOrZero(extras.ReturnUnusedFlowersPrice.Float64()),
+		}
+	}
+
+	return input
+}
+
+func pricingInputFromRecords(order *core.Record, frames []*core.Record, paperweight *core.Record) pricingInput {
+	src := &orderInvoiceSource{Order: order, Frames: frames, Paperweight: paperweight}
+	return pricingInputFromPayload(src.toInvoicePayload())
+}
+
+// computeTotals mirrors the FE buildTotals: line prices are ex VAT and VAT is added on top.
+// Only positive amounts are charged, matching the rows rendered on the invoice.
+func computeTotals(input pricingInput, vatRate float64) invoiceTotals {
+	subTotal := 0.0
+	add := func(amount float64) {
+		if amount > 0 {
+			subTotal += amount
+		}
+	}
+
+	for _, frame := range input.Frames {
+		add(frame.Price)
+		add(frame.MountPrice)
+		add(frame.GlassPrice)
+		add(frame.GlassEngravingPrice)
+	}
+	add(input.PaperweightPrice)
+	for _, amount := range input.ExtrasPrices {
+		add(amount)
+	}
+
+	subTotal = roundMoney(subTotal)
+	vatTotal := roundMoney(subTotal * vatRate)
+
+	return invoiceTotals{
+		SubTotal:   subTotal,
+		VatRate:    vatRate,
+		VatTotal:   vatTotal,
+		GrandTotal: roundMoney(subTotal + vatTotal),
+	}
+}
+
+func computeInvoiceTotals(payload invoicePayload) invoiceTotals {
+	return computeTotals(pricingInputFromPayload(payload), resolveVatRate())
+}
+
+// clientTotalsProvided is false when the FE didn't send totals at all (every field zero).
+func clientTotalsProvided(payload invoicePayload) bool {
+	t := payload.Totals
+	return t.SubTotal != 0 || t.VatRate != 0 || t.VatTotal != 0 || t.GrandTotal != 0
+}
+
+// compareClientTotals returns a readable description of every field where the FE totals
+// disagree with the server computed ones. Empty result means they match (or weren't sent).
+// The VAT rate is server config (INVOICE_VAT_RATE), not something a stale tab gets wrong, so
+// the VAT figures are only compared when the FE priced at the same rate; the server's win.
+func compareClientTotals(payload invoicePayload, computed invoiceTotals) []string {
+	if !clientTotalsProvided(payload) {
+		return nil
+	}
+
+	mismatches := []string{}
+	check := func(name string, client, server float64) {
+		if math.Abs(client-server) > totalsTolerance {
+			mismatches = append(mismatches, fmt.Sprintf("%s: client=%.2f server=%.2f", name, client, server))
+		}
+	}
+
+	check("subTotal", payload.Totals.SubTotal, computed.SubTotal)
+	if math.Abs(payload.Totals.VatRate-computed.VatRate) <= totalsTolerance {
+		check("vatTotal", payload.Totals.VatTotal, computed.VatTotal)
+		check("grandTotal", payload.Totals.GrandTotal, computed.GrandTotal)
+	}
+
+	return mismatches
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestComputeTotals(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		payload  string
+		vatRate  float64
+		expected invoiceTotals
+	}{
+		{
+			"empty order",
+			`{}`,
+			0.2,
+			invoiceTotals{VatRate: 0.2},
+		},
+		{
+			"frame with its extras",
+			`{"frames":[{"price":250,"extras":{"mountPrice":20,"glassPrice":"15.5","glassEngravingPrice":40}}]}`,
+			0.2,
+			invoiceTotals{SubTotal: 325.5, VatRate: 0.2, VatTotal: 65.1, GrandTotal: 390.6},
+		},
+		{
+			"paperweight under either key",
+			`{"paperWeightOrder":{"quantity":2,"price":45}}`,
+			0.2,
+			invoiceTotals{SubTotal: 45, VatRate: 0.2, VatTotal: 9, GrandTotal: 54},
+		},
+		{
+			"order extras",
+			`{"orderExtras":{"replacementFlowersPrice":30,"collectionPrice":15,"deliveryPrice":"12.50","returnUnusedFlowersPrice":10}}`,
+			0.2,
+			invoiceTotals{SubTotal: 67.5, VatRate: 0.2, VatTotal: 13.5, GrandTotal: 81},
+		},
+		{
+			"negative and empty amounts aren't charged",
+			`{"frames":[{"price":-50},{"price":""},{"price":100}],"paperweight":{"price":null}}`,
+			0.2,
+			invoiceTotals{SubTotal: 100, VatRate: 0.2, VatTotal: 20, GrandTotal: 120},
+		},
+		{
+			"vat rounds to the penny",
+			`{"frames":[{"price":33.33}]}`,
+			0.2,
+			invoiceTotals{SubTotal: 33.33, VatRate: 0.2, VatTotal: 6.67, GrandTotal: 40},
+		},
+		{
+			"float sums round to the penny",
+			`{"frames":[{"price":0.1},{"price":0.2}]}`,
+			0,
+			invoiceTotals{SubTotal: 0.3, GrandTotal: 0.3},
+		},
+	}
+
+	for _, s := range scenarios {
+		var payload invoicePayload
+		if err := json.Unmarshal([]byte(s.payload), &payload); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got := computeTotals(pricingInputFromPayload(payload), s.vatRate); got != s.expected {
+			t.Errorf("%s: expected %+v, got %+v", s.name, s.expected, got)
+		}
+	}
+}
+
+func TestCompareClientTotals(t *testing.T) {
+	computed := invoiceTotals{SubTotal: 250, VatRate: 0.2, VatTotal: 50, GrandTotal: 300}
+
+	scenarios := []struct {
+		name     string
+		totals   string
+		expected []string
+	}{
+		{"not sent", `{}`, nil},
+		{"matching", `{"subTotal":250,"vatRate":0.2,"vatTotal":50,"grandTotal":300}`, []string{}},
+		{"within a penny's rounding", `{"subTotal":250.004,"vatRate":0.2,"vatTotal":49.996,"grandTotal":300}`, []string{}},
+		{
+			"stale figures",
+			`{"subTotal":200,"vatRate":0.2,"vatTotal":40,"grandTotal":300.01}`,
+			[]string{
+				"subTotal: client=200.00 server=250.00",
+				"vatTotal: client=40.00 server=50.00",
+				"grandTotal: client=300.01 server=300.00",
+			},
+		},
+		// the server's configured rate wins, so a client on another rate only has its subtotal checked
+		{"other vat rate", `{"subTotal":250,"vatRate":0.15,"vatTotal":37.5,"grandTotal":287.5}`, []string{}},
+		{"other vat rate and stale", `{"subTotal":240,"vatRate":0.15,"vatTotal":36,"grandTotal":276}`, []string{"subTotal: client=240.00 server=250.00"}},
+	}
+
+	for _, s := range scenarios {
+		var payload invoicePayload
+		if err := json.Unmarshal([]byte(`{"totals":`+s.totals+`}`), &payload); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		got := compareClientTotals(payload, computed)
+		if (got == nil) != (s.expected == nil) || !slices.Equal(got, s.expected) {
+			t.Errorf("%s: expected %q, got %q", s.name, s.expected, got)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// keeps the recommendation email under typical provider size limits
+const maxReferenceImageBytes = 15 << 20
+
+// emailFrameRecommendation is one frame item as shown in the recommendation email.
+type emailFrameRecommendation struct {
+	Label           string // "Item 1"
+	Layout          string
+	FrameType       string
+	MountColour     string
+	GlassType       string
+	MeasuredSize    string
+	RecommendedSize string
+	Options         []emailPriceOption
+	Total           string
+}
+
+type emailPriceOption struct {
+	Label string
+	Price string
+}
+
+type referenceImage struct {
+	Name string
+	Data []byte
+}
+
+// formatInches renders a width/height pair as "14x16 inches", or "" when either is missing.
+func formatInches(width Number, height Number) string {
+	w, h := width.Float64(), height.Float64()
+	if w == nil || h == nil || *w <= 0 || *h <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%sx%s inches", strconv.FormatFloat(*w, 'f', -1, 64), strconv.FormatFloat(*h, 'f', -1, 64))
+}
+
+// mountColourName drops the supplier code from select values like "Cream - 8674".
+func mountColourName(value string) string {
+	name, _, _ := strings.Cut(value, " - ")
+	return strings.TrimSpace(name)
+}
+
+func buildEmailFrameRecommendation(index int, frame framePayload) emailFrameRecommendation {
+	rec := emailFrameRecommendation{
+		Label:       fmt.Sprintf("Item %d", index+1),
+		Layout:      strings.TrimSpace(frame.Layout),
+		FrameType:   strings.TrimSpace(frame.FrameType),
+		MountColour: mountColourName(frame.MountColour),
+		GlassType:   strings.TrimSpace(frame.GlassType),
+	}
+	if price := frame.Price.Float64(); price != nil {
+		rec.Total = formatMoney(*price)
+	}
+
+	extras := frame.Extras
+	if extras == nil {
+		return rec
+	}
+
+	rec.MeasuredSize = formatInches(extras.MeasuredWidthIn, extras.MeasuredHeightIn)
+	rec.RecommendedSize = formatInches(extras.RecommendedSizeWidthIn, extras.RecommendedSizeHeightIn)
+
+	frameLabel, mountLabel := "Frame", "Mount"
+	if rec.FrameType != "" {
+		frameLabel = rec.FrameType + " frame"
+	}
+	if rec.MountColour != "" {
+		mountLabel = rec.MountColour + " mount"
+	}
+
+	for _, option := range []struct {
+		label string
+		price Number
+	}{
+		{frameLabel, extras.FramePrice},
+		{mountLabel, extras.MountPrice},
+		{firstNonEmpty(rec.GlassType, "Glass"), extras.GlassPrice},
+		{"Glass engraving", extras.GlassEngravingPrice},
+	} {
+		if price := option.price.Float64(); price != nil && *price > 0 {
+			rec.Options = append(rec.Options, emailPriceOption{Label: option.label, Price: formatMoney(*price)})
+		}
+	}
+
+	return rec
+}
+
+// loadFrameReferenceImages reads the referenceImages stored on the payload's frame items, named
+// "item<n>-<file>" so the customer can tell which bouquet each photo belongs to. Frames without
+// an id (or no longer stored) are skipped; images past maxReferenceImageBytes are left off.
+func loadFrameReferenceImages(app core.App, frames []framePayload) ([]referenceImage, error) {
+	var images []referenceImage
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	total := 0
+	for i, frame := range frames {
+		frameId := strings.TrimSpace(frame.FrameID)
+		if frameId == "" {
+			continue
+		}
+		record, err := app.FindRecordById("order_frame_items", frameId)
+		if err != nil {
+			continue
+		}
+
+		for _, filename := range record.GetStringSlice("referenceImages") {
+			reader, err := fsys.GetReader(record.BaseFilesPath() + "/" + filename)
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", filename, err)
+			}
+			data, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", filename, err)
+			}
+
+			if total+len(data) > maxReferenceImageBytes {
+				fmt.Println("reference images over size limit, skipping:", frameId, filename)
+				continue
+			}
+			total += len(data)
+
+			images = append(images, referenceImage{
+				Name: fmt.Sprintf("item%d-%s", i+1, path.Base(filename)),
+				Data: data,
+			})
+		}
+	}
+
+	return images, nil
+}
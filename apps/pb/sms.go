@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const defaultSmsCountryCode = "44"
+
+var (
+	smsPhonePattern   = regexp.MustCompile(`^\+[1-9]\d{7,14}$`)
+	smsPhoneSeparator = regexp.MustCompile(`[\s\-().]`)
+)
+
+// smsSender delivers a single text and returns the provider's message id.
+type smsSender interface {
+	Name() string
+	Send(to string, body string) (string, error)
+}
+
+var (
+	smsSenderMu       sync.RWMutex
+	smsSenderResolved bool
+	activeSmsSender   smsSender
+)
+
+// currentSmsSender resolves SMS_PROVIDER once:
+//   - "twilio": TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and SMS_FROM
+//   - "log": prints texts instead of sending them (local dev)
+//   - unset: texts are disabled and nil is returned
+func currentSmsSender() smsSender {
+	smsSenderMu.RLock()
+	sender, resolved := activeSmsSender, smsSenderResolved
+	smsSenderMu.RUnlock()
+
+	if resolved {
+		return sender
+	}
+
+	smsSenderMu.Lock()
+	defer smsSenderMu.Unlock()
+
+	if !smsSenderResolved {
+		activeSmsSender = newSmsSenderFromEnv()
+		smsSenderResolved = true
+	}
+	return activeSmsSender
+}
+
+// setSmsSender overrides the configured provider (tests, custom setups). nil disables texts.
+func setSmsSender(sender smsSender) {
+	smsSenderMu.Lock()
+	defer smsSenderMu.Unlock()
+
+	activeSmsSender = sender
+	smsSenderResolved = true
+}
+
+func newSmsSenderFromEnv() smsSender {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SMS_PROVIDER"))) {
+	case "twilio":
+		return &twilioSmsSender{
+			accountSid: strings.TrimSpace(os.Getenv("TWILIO_ACCOUNT_SID")),
+			authToken:  strings.TrimSpace(os.Getenv("TWILIO_AUTH_TOKEN")),
+			from:       strings.TrimSpace(os.Getenv("SMS_FROM")),
+			client:     &http.Client{Timeout: 15 * time.Second},
+		}
+	case "log":
+		return &logSmsSender{}
+	}
+	return nil
+}
+
+// normalizeSmsPhone turns what staff typed ("07700 900123", "+44 7700 900123", "0044...")
+// into E.164. Numbers starting with a single 0 get SMS_DEFAULT_COUNTRY_CODE (default 44).
+func normalizeSmsPhone(raw string) (string, error) {
+	// "+44 (0)7700 ..." carries the trunk 0 for people dialling locally
+	phone := smsPhoneSeparator.ReplaceAllString(strings.ReplaceAll(strings.TrimSpace(raw), "(0)", ""), "")
+	switch {
+	case phone == "":
+		return "", errors.New("no phone number")
+	case strings.HasPrefix(phone, "00"):
+		phone = "+" + phone[2:]
+	case strings.HasPrefix(phone, "0"):
+		code := firstNonEmpty(strings.TrimPrefix(strings.TrimSpace(os.Getenv("SMS_DEFAULT_COUNTRY_CODE")), "+"), defaultSmsCountryCode)
+		phone = "+" + code + phone[1:]
+	}
+	if !smsPhonePattern.MatchString(phone) {
+		return "", fmt.Errorf("invalid phone number %q", raw)
+	}
+	return phone, nil
+}
+
+// renderSmsTemplate executes pb_hooks/views/sms.<key>.txt against the email view model,
+// collapsing whitespace so line breaks in the file don't cost characters.
+func renderSmsTemplate(viewsDir string, key string, view emailViewModel) (string, error) {
+	name := "sms." + key + ".txt"
+	content, err := os.ReadFile(filepath.Join(viewsDir, name))
+	if err != nil {
+		return "", fmt.Errorf("sms template %q not found: %w", key, err)
+	}
+
+	tmpl, err := texttemplate.New(name).Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("parse %s failed: %w", name, err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, view); err != nil {
+		return "", fmt.Errorf("execute %s failed: %w", name, err)
+	}
+	return strings.Join(strings.Fields(body.String()), " "), nil
+}
+
+// sendSmsForLog sends the text and moves the log to sent or failed, the same way the outbox
+// does for emails. Texts are not retried.
+func sendSmsForLog(app core.App, sender smsSender, logRec *core.Record, to string, body string) error {
+	sendStart := time.Now()
+	providerId, err := sender.Send(to, body)
+	sendMs := time.Since(sendStart).Milliseconds()
+
+	if err != nil {
+		updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
+			"stage":    "send_sms",
+			"provider": sender.Name(),
+			"sendMs":   sendMs,
+		})
+		return err
+	}
+
+	if logRec != nil {
+		logRec.Set("sentAt", types.NowDateTime())
+	}
+	updateEmailLog(app, logRec, "sent", "", map[string]any{
+		"stage":             "sent",
+		"provider":          sender.Name(),
+		"providerMessageId": providerId,
+		"sendMs":            sendMs,
+	})
+	return nil
+}
+
+// smsRecipient returns the customer's E.164 number when they opted in to texts, or "".
+func smsRecipient(customer *core.Record) string {
+	if customer == nil || !customer.GetBool("smsOptIn") {
+		return ""
+	}
+	phone, err := normalizeSmsPhone(customer.GetString("telephone"))
+	if err != nil {
+		return ""
+	}
+	return phone
+}
+
+// sendOrderSms renders sms.<templateKey>.txt for the order's customer, logs the attempt and
+// sends it. It returns false without error when texts are disabled or the customer hasn't
+// opted in (or has no usable number).
+func sendOrderSms(app core.App, src *orderInvoiceSource, view emailViewModel, logCtx emailLogContext, meta map[string]any) (bool, error) {
+	sender := currentSmsSender()
+	to := smsRecipient(src.Customer)
+	if sender == nil || to == "" {
+		return false, nil
+	}
+
+	body, renderErr := renderSmsTemplate(resolvePathFromExecutable("pb_hooks", "views"), strings.TrimPrefix(logCtx.TemplateKey, "sms."), view)
+
+	logRec, err := createSmsLog(app, nil, to, view.Customer.DisplayName, logCtx, mergeMeta(meta, map[string]any{"body": body}))
+	if err != nil {
+		// the log claims reminder stages, so a text that can't be logged is not sent
+		return false, fmt.Errorf("create sms log: %w", err)
+	}
+
+	if renderErr != nil {
+		updateEmailLog(app, logRec, "failed", renderErr.Error(), map[string]any{"stage": "render_sms"})
+		return false, renderErr
+	}
+
+	if err := sendSmsForLog(app, sender, logRec, to, body); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//
+// -------- twilio --------
+//
+
+type twilioSmsSender struct {
+	accountSid string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+func (s *twilioSmsSender) Name() string { return "twilio" }
+
+func (s *twilioSmsSender) Send(to string, body string) (string, error) {
+	if s.accountSid == "" || s.authToken == "" || s.from == "" {
+		return "", errors.New("twilio is not configured (TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, SMS_FROM)")
+	}
+
+	form := url.Values{"To": {to}, "From": {s.from}, "Body": {body}}
+	endpoint := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", url.PathEscape(s.accountSid))
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(s.accountSid, s.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Sid     string `json:"sid"`
+		Message string `json:"message"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&result)
+
+	if res.StatusCode >= 300 {
+		return "", fmt.Errorf("twilio: %s (status %d)", firstNonEmpty(result.Message, "request failed"), res.StatusCode)
+	}
+	return result.Sid, nil
+}
+
+//
+// -------- log --------
+//
+
+// logSmsSender prints texts to stdout, for running locally without a provider.
+type logSmsSender struct{}
+
+func (s *logSmsSender) Name() string { return "log" }
+
+func (s *logSmsSender) Send(to string, body string) (string, error) {
+	fmt.Println("sms to", to+":", body)
+	return "log_" + security.RandomString(10), nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type fakeSms struct {
+	To   string
+	Body string
+}
+
+// fakeSmsSender keeps texts in memory so tests can assert on them. Set Err to make sends fail.
+type fakeSmsSender struct {
+	mu   sync.Mutex
+	Sent []fakeSms
+	Err  error
+}
+
+func (s *fakeSmsSender) Name() string { return "fake" }
+
+func (s *fakeSmsSender) Send(to string, body string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Err != nil {
+		return "", s.Err
+	}
+	s.Sent = append(s.Sent, fakeSms{To: to, Body: body})
+	return fmt.Sprintf("fake_%d", len(s.Sent)), nil
+}
+
+func (s *fakeSmsSender) Messages() []fakeSms {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]fakeSms(nil), s.Sent...)
+}
+
+// useFakeSmsSender routes texts to a fresh fakeSmsSender for the rest of the test.
+func useFakeSmsSender(t testing.TB) *fakeSmsSender {
+	sender := &fakeSmsSender{}
+	setSmsSender(sender)
+	t.Cleanup(func() { setSmsSender(nil) })
+	return sender
+}
+
+func findSmsLogs(t testing.TB, app core.App, orderId string) []*core.Record {
+	logs, err := app.FindRecordsByFilter("email_logs", `channel = "sms" && orderId = {:orderId}`, "created", 0, 0, dbx.Params{"orderId": orderId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logs
+}
+
+// createSmsTestOrder creates an order whose customer has a phone number and the given opt-in.
+func createSmsTestOrder(t testing.TB, app core.App, optIn bool) *core.Record {
+	order := createTestOrder(t, app, 0)
+	customer := createTestCustomer(t, app, order.Id, "jane@example.com")
+	customer.Set("telephone", "07700 900123")
+	customer.Set("smsOptIn", optIn)
+	if err := app.Save(customer); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestNormalizeSmsPhone(t *testing.T) {
+	scenarios := []struct {
+		raw      string
+		expected string
+	}{
+		{"07700 900123", "+447700900123"},
+		{"+44 (0)7700 900-123", "+447700900123"},
+		{"0044 7700 900123", "+447700900123"},
+		{"(07700) 900.123", "+447700900123"},
+		{"+1 415 555 0100", "+14155550100"},
+		{"", ""},
+		{"12345", ""},
+		{"07700 9001x3", ""},
+	}
+
+	for _, s := range scenarios {
+		got, err := normalizeSmsPhone(s.raw)
+		if s.expected == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", s.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != s.expected {
+			t.Errorf("%q: expected %q, got %q (%v)", s.raw, s.expected, got, err)
+		}
+	}
+
+	t.Setenv("SMS_DEFAULT_COUNTRY_CODE", "+353")
+	if got, _ := normalizeSmsPhone("087 123 4567"); got != "+353871234567" {
+		t.Errorf("expected the configured country code, got %q", got)
+	}
+}
+
+func TestSendStatusUpdateSms(t *testing.T) {
+	app := newTestApp(t)
+	sender := useFakeSmsSender(t)
+
+	update := emailStatusUpdate{Event: "order_ready", FromStatus: "In progress", ToStatus: "Ready"}
+
+	order := createSmsTestOrder(t, app, true)
+	if sent, err := sendStatusUpdateSms(app, order.Id, update, ""); err != nil || !sent {
+		t.Fatalf("expected the text to be sent, got %v", err)
+	}
+	messages := sender.Messages()
+	if len(messages) != 1 || messages[0].To != "+447700900123" || !strings.Contains(messages[0].Body, "is ready") {
+		t.Fatalf("expected the ready text to the normalised number, got %+v", messages)
+	}
+	logs := findSmsLogs(t, app, order.Id)
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 sms log, got %d", len(logs))
+	}
+	assertEmailLog(t, logs[0], "sent", "sent")
+	if logs[0].GetString("toPhone") != "+447700900123" || logs[0].GetString("templateKey") != "sms.status_update" {
+		t.Errorf("expected the number and template to be logged, got %q / %q", logs[0].GetString("toPhone"), logs[0].GetString("templateKey"))
+	}
+	if id := readEmailLogMeta(logs[0])["providerMessageId"]; id != "fake_1" {
+		t.Errorf("expected the provider id to be logged, got %v", id)
+	}
+
+	// customers who didn't opt in get nothing, not even a log row
+	notOptedIn := createSmsTestOrder(t, app, false)
+	if sent, err := sendStatusUpdateSms(app, notOptedIn.Id, update, ""); err != nil || sent {
+		t.Errorf("expected no text without opt-in, got %v / %v", sent, err)
+	}
+	if logs := findSmsLogs(t, app, notOptedIn.Id); len(logs) != 0 {
+		t.Errorf("expected no sms log without opt-in, got %d", len(logs))
+	}
+
+	sender.Err = errors.New("provider down")
+	failing := createSmsTestOrder(t, app, true)
+	if _, err := sendStatusUpdateSms(app, failing.Id, update, ""); err == nil {
+		t.Error("expected the send error")
+	}
+	logs = findSmsLogs(t, app, failing.Id)
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 sms log, got %d", len(logs))
+	}
+	assertEmailLog(t, logs[0], "failed", "send_sms")
+	if logs[0].GetString("error") != "provider down" {
+		t.Errorf("expected the provider error to be logged, got %q", logs[0].GetString("error"))
+	}
+}
+
+func TestPaymentReminderSmsClaimsStage(t *testing.T) {
+	app := newTestApp(t)
+	sender := useFakeSmsSender(t)
+
+	order := createSmsTestOrder(t, app, true)
+	stage := defaultPaymentReminderStages[0]
+
+	if sent, err := sendPaymentReminderSms(app, order.Id, stage); err != nil || !sent {
+		t.Fatalf("expected the reminder text to be sent, got %v", err)
+	}
+	// the stage is already claimed, so a second run doesn't text again
+	if sent, err := sendPaymentReminderSms(app, order.Id, stage); err == nil || sent {
+		t.Errorf("expected the second reminder to be refused, got %v / %v", sent, err)
+	}
+	if got := len(sender.Messages()); got != 1 {
+		t.Errorf("expected 1 text, got %d", got)
+	}
+
+	// a failed text doesn't hold the stage, so the next run retries it
+	next := defaultPaymentReminderStages[1]
+	sender.Err = errors.New("provider down")
+	if _, err := sendPaymentReminderSms(app, order.Id, next); err == nil {
+		t.Fatal("expected the send error")
+	}
+	sender.Err = nil
+	if sent, err := sendPaymentReminderSms(app, order.Id, next); err != nil || !sent {
+		t.Errorf("expected the retry to be sent, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/pocketbase/pocketbase/tools/routine"
+)
+
+var orderStatusLabels = map[string]string{
+	"draft":       "Draft",
+	"in_progress": "In progress",
+	"ready":       "Ready",
+	"delivered":   "Delivered",
+	"cancelled":   "Cancelled",
+}
+
+// statuses that tell the customer something worth an email
+var notifyOrderStatuses = map[string]bool{
+	"ready":     true,
+	"delivered": true,
+}
+
+// emailStatusUpdate is the status part of the view model for email.status_update.
+type emailStatusUpdate struct {
+	// Event is "order_ready", "order_delivered" or "framing_complete"
+	Event      string
+	FromStatus string
+	ToStatus   string
+	FrameLabel string // set for framing_complete
+}
+
+func registerStatusEmailHooks(app *pocketbase.PocketBase) {
+	app.OnRecordAfterUpdateSuccess("orders").BindFunc(func(e *core.RecordEvent) error {
+		from := e.Record.Original().GetString("orderStatus")
+		to := e.Record.GetString("orderStatus")
+
+		if from != to && notifyOrderStatuses[to] {
+			update := emailStatusUpdate{
+				Event:      "order_" + to,
+				FromStatus: firstNonEmpty(orderStatusLabels[from], from),
+				ToStatus:   firstNonEmpty(orderStatusLabels[to], to),
+			}
+			if err := queueStatusUpdateEmail(e.App, e.Record.Id, update, ""); err != nil {
+				e.App.Logger().Error("status update email failed", "orderId", e.Record.Id, "error", err.Error())
+			}
+			sendStatusUpdateSmsAsync(e.App, e.Record.Id, update, "")
+		}
+
+		return e.Next()
+	})
+
+	app.OnRecordAfterUpdateSuccess("order_frame_items").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetBool("framingComplete") && !e.Record.Original().GetBool("framingComplete") {
+			if err := queueFramingCompleteEmail(e.App, e.Record); err != nil {
+				e.App.Logger().Error("framing complete email failed", "frameItemId", e.Record.Id, "error", err.Error())
+			}
+		}
+
+		return e.Next()
+	})
+}
+
+func queueFramingCompleteEmail(app core.App, frame *core.Record) error {
+	orders, err := app.FindRecordsByFilter(
+		"orders",
+		"frameOrderId ~ {:frameId}",
+		"",
+		1,
+		0,
+		dbx.Params{"frameId": frame.Id},
+	)
+	if err != nil {
+		return err
+	}
+	if len(orders) == 0 {
+		return nil
+	}
+	order := orders[0]
+
+	frameLabel := "Your frame"
+	for i, frameId := range order.GetStringSlice("frameOrderId") {
+		if frameId == frame.Id {
+			frameLabel = fmt.Sprintf("Item %d", i+1)
+			break
+		}
+	}
+
+	update := emailStatusUpdate{
+		Event:      "framing_complete",
+		FromStatus: "Not framed",
+		ToStatus:   "Framed",
+		FrameLabel: frameLabel,
+	}
+	sendStatusUpdateSmsAsync(app, order.Id, update, frame.Id)
+	return queueStatusUpdateEmail(app, order.Id, update, frame.Id)
+}
+
+// queueStatusUpdateEmail renders email.status_update for the order and puts it in the outbox.
+// Orders that opted out, or whose customer has no email, are skipped without error.
+func queueStatusUpdateEmail(app core.App, orderId string, update emailStatusUpdate, frameItemId string) error {
+	src, err := loadOrderInvoiceSource(app, orderId)
+	if err != nil {
+		return err
+	}
+	if src.Order.GetBool("statusEmailsOptOut") {
+		return nil
+	}
+
+	payload := src.toInvoicePayload()
+	if strings.TrimSpace(payload.Customer.Email) == "" {
+		return nil
+	}
+
+	view := buildEmailViewModel(payload)
+	view.Status = &update
+
+	logCtx, meta := buildEmailLogContextFromPayload(payload, "status_update", update.Event, "email.status_update")
+	logCtx.EventNote = fmt.Sprintf("%s -> %s", update.FromStatus, update.ToStatus)
+	logCtx.FrameItemId = frameItemId
+	meta["source"] = "status_hook"
+
+	rendered, err := renderEmailTemplate(app, resolvePathFromExecutable("pb_hooks", "views"), logCtx.TemplateKey, "", view)
+
+	subject := firstNonEmpty(rendered.Subject, "Your order update")
+	logRec, logErr := createEmailLog(app, nil, payload.Customer.Email, buildCustomerDisplayName(payload), subject, logCtx, meta)
+	if logErr != nil {
+		fmt.Println("email log create failed:", logErr.Error())
+	}
+
+	if err != nil {
+		updateEmailLog(app, logRec, "failed", err.Error(), map[string]any{
+			"stage": "render_email",
+		})
+		return err
+	}
+	recordRenderedEmail(logRec, rendered)
+
+	msg := &mailer.Message{
+		From: mail.Address{
+			Address: app.Settings().Meta.SenderAddress,
+			Name:    app.Settings().Meta.SenderName,
+		},
+		To:      []mail.Address{{Address: payload.Customer.Email}},
+		Subject: subject,
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+	}
+
+	_, err = queueEmailForLog(app, logRec, msg, nil)
+	return err
+}
+
+// sendStatusUpdateSmsAsync texts the update to customers who opted in. It runs in the
+// background so a slow SMS provider doesn't hold up the save that triggered it.
+func sendStatusUpdateSmsAsync(app core.App, orderId string, update emailStatusUpdate, frameItemId string) {
+	if currentSmsSender() == nil {
+		return
+	}
+	routine.FireAndForget(func() {
+		if _, err := sendStatusUpdateSms(app, orderId, update, frameItemId); err != nil {
+			app.Logger().Error("status update sms failed", "orderId", orderId, "error", err.Error())
+		}
+	})
+}
+
+func sendStatusUpdateSms(app core.App, orderId string, update emailStatusUpdate, frameItemId string) (bool, error) {
+	src, err := loadOrderInvoiceSource(app, orderId)
+	if err != nil {
+		return false, err
+	}
+	if src.Order.GetBool("statusEmailsOptOut") {
+		return false, nil
+	}
+
+	payload := src.toInvoicePayload()
+	view := buildEmailViewModel(payload)
+	view.Status = &update
+
+	logCtx, meta := buildEmailLogContextFromPayload(payload, "status_update", update.Event, "sms.status_update")
+	logCtx.EventNote = fmt.Sprintf("%s -> %s", update.FromStatus, update.ToStatus)
+	logCtx.FrameItemId = frameItemId
+	meta["source"] = "status_hook"
+
+	return sendOrderSms(app, src, view, logCtx, meta)
+}
@@ -8,13 +8,11 @@ import (
 	"html/template"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -28,11 +26,18 @@ type emailContextPayload struct {
 	FrameItemId       string `json:"frameItemId"`
 	PaperweightItemId string `json:"paperweightItemId"`
 	Source            string `json:"source"` // optional; store in meta
+
+	// optional per-send overrides, see email_options.go
+	Subject string   `json:"subject"`
+	Message string   `json:"message"` // staff note shown in the email body
+	Cc      []string `json:"cc"`
+	Bcc     []string `json:"bcc"`
+	ReplyTo string   `json:"replyTo"`
 }
 
 func isAllowedEmailType(v string) bool {
 	switch v {
-	case "invoice", "recommendation_bouquet", "recommendation_paperweight", "status_update", "comment", "generic":
+	case "invoice", "credit_note", "recommendation_bouquet", "recommendation_paperweight", "status_update", "payment_reminder", "comment", "generic":
 		return true
 	default:
 		return false
@@ -112,6 +117,9 @@ func (d *StringDate) UnmarshalJSON(b []byte) error {
 type invoicePayload struct {
 	EmailContext *emailContextPayload `json:"emailContext"`
 
+	// re-sends re-use the stored invoice document unless this is set
+	IssueNewVersion bool `json:"issueNewVersion"`
+
 	Customer struct {
 		Title       string `json:"title"`
 		FirstName   string `json:"firstName"`
@@ -126,6 +134,7 @@ type invoicePayload struct {
 
 	Order struct {
 		OrderNo      Number     `json:"orderNo"`
+		OrderRef     string     `json:"orderRef"` // formatted reference, when ORDER_REF_FORMAT is set
 		OccasionDate StringDate `json:"occasionDate"`
 
 		BillingAddressLine1 string `json:"billingAddressLine1"`
@@ -139,35 +148,9 @@ type invoicePayload struct {
 		Created string `json:"created"`
 	} `json:"order"`
 
-	OrderExtras *struct {
-		ReplacementFlowers       bool   `json:"replacementFlowers"`
-		ReplacementFlowersQty    Number `json:"replacementFlowersQty"`
-		ReplacementFlowersPrice  Number `json:"replacementFlowersPrice"`
-		CollectionQty            Number `json:"collectionQty"`
-		CollectionPrice          Number `json:"collectionPrice"`
-		DeliveryQty              Number `json:"deliveryQty"`
-		DeliveryPrice            Number `json:"deliveryPrice"`
-		ReturnUnusedFlowers      bool   `json:"returnUnusedFlowers"`
-		ReturnUnusedFlowersPrice Number `json:"returnUnusedFlowersPrice"`
-		ArtistHours              Number `json:"artistHours"`
-		Notes                    string `json:"notes"`
-	} `json:"orderExtras"`
-
-	Frames []struct {
-		Size           string `json:"size"`
-		FrameType      string `json:"frameType"`
-		GlassType      string `json:"glassType"`
-		Inclusions     string `json:"inclusions"`
-		MountColour    string `json:"mountColour"`
-		GlassEngraving string `json:"glassEngraving"`
-
-		Price  Number `json:"price"`
-		Extras *struct {
-			MountPrice          Number `json:"mountPrice"`
-			GlassPrice          Number `json:"glassPrice"`
-			GlassEngravingPrice Number `json:"glassEngravingPrice"`
-		} `json:"extras"`
-	} `json:"frames"`
+	OrderExtras *orderExtrasPayload `json:"orderExtras"`
+
+	Frames []framePayload `json:"frames"`
 
 	// Support BOTH keys:
 	Paperweight      *paperweightPayload `json:"paperweight"`
@@ -181,6 +164,47 @@ type invoicePayload struct {
 	} `json:"totals"`
 }
 
+type orderExtrasPayload struct {
+	ReplacementFlowers       bool   `json:"replacementFlowers"`
+	ReplacementFlowersQty    Number `json:"replacementFlowersQty"`
+	ReplacementFlowersPrice  Number `json:"replacementFlowersPrice"`
+	CollectionQty            Number `json:"collectionQty"`
+	CollectionPrice          Number `json:"collectionPrice"`
+	DeliveryQty              Number `json:"deliveryQty"`
+	DeliveryPrice            Number `json:"deliveryPrice"`
+	ReturnUnusedFlowers      bool   `json:"returnUnusedFlowers"`
+	ReturnUnusedFlowersPrice Number `json:"returnUnusedFlowersPrice"`
+	ArtistHours              Number `json:"artistHours"`
+	Notes                    string `json:"notes"`
+}
+
+type framePayload struct {
+	FrameID        string `json:"frameId"` // order_frame_items id, used to find reference images
+	Size           string `json:"size"`
+	Layout         string `json:"layout"`
+	FrameType      string `json:"frameType"`
+	GlassType      string `json:"glassType"`
+	Inclusions     string `json:"inclusions"`
+	MountColour    string `json:"mountColour"`
+	GlassEngraving string `json:"glassEngraving"`
+
+	Price  Number              `json:"price"`
+	Extras *frameExtrasPayload `json:"extras"`
+}
+
+type frameExtrasPayload struct {
+	FramePrice          Number `json:"framePrice"`
+	MountPrice          Number `json:"mountPrice"`
+	GlassPrice          Number `json:"glassPrice"`
+	GlassEngravingPrice Number `json:"glassEngravingPrice"`
+
+	// sizes in inches, only used by the recommendation email
+	MeasuredWidthIn         Number `json:"measuredWidthIn"`
+	MeasuredHeightIn        Number `json:"measuredHeightIn"`
+	RecommendedSizeWidthIn  Number `json:"recommendedSizeWidthIn"`
+	RecommendedSizeHeightIn Number `json:"recommendedSizeHeightIn"`
+}
+
 type paperweightPayload struct {
 	Quantity Number `json:"quantity"`
 	Price    Number `json:"price"`
@@ -204,6 +228,13 @@ type invoiceRow struct {
 }
 
 type invoiceViewModel struct {
+	// Title/DocumentNoLabel switch the shared template between invoice and credit note
+	Title             string
+	DocumentNoLabel   string
+	IsCreditNote      bool
+	CreditedInvoiceNo string
+	Reason            string
+
 	Address      string
 	OccasionDate string
 	InvoiceDate  string
@@ -213,6 +244,7 @@ type invoiceViewModel struct {
 	SubTotal     string
 	VatTotal     string
 	GrandTotal   string
+	Payments     []invoicePaymentRow
 	Credits      string
 	BalanceDue   string
 }
@@ -235,7 +267,7 @@ func formatDate(value string) string {
 	return parsed.Format("02/01/2006")
 }
 
-func formatInvoiceNo(value *float64) string {
+func formatOrderNo(value *float64) string {
 	if value == nil {
 		return "-"
 	}
@@ -377,7 +409,7 @@ func buildInvoiceRows(payload invoicePayload) []invoiceRow {
 	return rows
 }
 
-func buildInvoiceViewModel(payload invoicePayload) invoiceViewModel {
+func buildInvoiceViewModel(payload invoicePayload, payments []invoicePayment) invoiceViewModel {
 	displayName := payload.Customer.DisplayName
 	if displayName == "" {
 		displayName = strings.TrimSpace(strings.Join([]string{
@@ -407,19 +439,25 @@ func buildInvoiceViewModel(payload invoicePayload) invoiceViewModel {
 
 	occasionDate := formatDate(string(payload.Order.OccasionDate))
 
+	// never trust FE totals; price from the rows we are about to render
+	totals := computeInvoiceTotals(payload)
+	credits := sumPayments(payments)
+
 	return invoiceViewModel{
-		Address:      address,
-		OccasionDate: occasionDate,
-		InvoiceDate:  formatDate(time.Now().Format("2006-01-02")),
-		InvoiceNo:    formatInvoiceNo(payload.Order.OrderNo.Float64()),
-		Rows:         buildInvoiceRows(payload),
-		Notes:        notes,
-		SubTotal:     formatMoney(payload.Totals.SubTotal),
-		VatTotal:     formatMoney(payload.Totals.VatTotal),
-		GrandTotal:   formatMoney(payload.Totals.GrandTotal),
-		Credits:      formatMoney(payload.Totals.GrandTotal),
-		// Need to sort out how to calculate balance due
-		BalanceDue: formatMoney(payload.Totals.GrandTotal),
+		Title:           "INVOICE",
+		DocumentNoLabel: "Invoice No",
+		Address:         address,
+		OccasionDate:    occasionDate,
+		InvoiceDate:     formatDate(time.Now().Format("2006-01-02")),
+		InvoiceNo:       "-", // allocated when the invoice is issued, see invoice_numbers.go
+		Rows:            buildInvoiceRows(payload),
+		Notes:           notes,
+		SubTotal:        formatMoney(totals.SubTotal),
+		VatTotal:        formatMoney(totals.VatTotal),
+		GrandTotal:      formatMoney(totals.GrandTotal),
+		Payments:        buildInvoicePaymentRows(payments),
+		Credits:         formatMoney(credits),
+		BalanceDue:      formatMoney(roundMoney(totals.GrandTotal - credits)),
 	}
 }
 
@@ -496,52 +534,6 @@ func listTemplateNames(t *template.Template) []string {
 	return out
 }
 
-func renderInvoicePdf(html string) ([]byte, error) {
-	bin := os.Getenv("INVOICE_PDF_BIN")
-	if strings.TrimSpace(bin) == "" {
-		bin = "wkhtmltopdf"
-	}
-
-	tempDir := os.TempDir()
-
-	htmlFile, err := os.CreateTemp(tempDir, "invoice-*.html")
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = htmlFile.Close() }()
-	defer os.Remove(htmlFile.Name())
-
-	if _, err := htmlFile.WriteString(html); err != nil {
-		return nil, err
-	}
-	if err := htmlFile.Close(); err != nil {
-		return nil, err
-	}
-
-	pdfPath := strings.TrimSuffix(htmlFile.Name(), ".html") + ".pdf"
-	defer os.Remove(pdfPath)
-
-	cmd := exec.Command(
-		bin,
-		"--enable-local-file-access",
-		"--print-media-type",
-		htmlFile.Name(),
-		pdfPath,
-	)
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		msg := strings.TrimSpace(stderr.String())
-		if msg == "" {
-			return nil, err
-		}
-		return nil, fmt.Errorf("wkhtmltopdf failed: %w: %s", err, msg)
-	}
-
-	return os.ReadFile(pdfPath)
-}
-
 // helper: read raw body so we can return an actionable error if JSON doesn't match
 func bindPayload(e *core.RequestEvent, dst any) error {
 	raw, err := io.ReadAll(e.Request.Body)
@@ -670,7 +662,16 @@ func buildEmailLogContextFromPayload(
 
 // Creates an email_logs record with status=attempted.
 // Best practice: call this early; if it fails, do not block sending.
-func createEmailLog(app *pocketbase.PocketBase, e *core.RequestEvent, toEmail, toName, subject string, ctx emailLogContext, meta map[string]any) (*core.Record, error) {
+func createEmailLog(app core.App, e *core.RequestEvent, toEmail, toName, subject string, ctx emailLogContext, meta map[string]any) (*core.Record, error) {
+	return createMessageLog(app, e, "email", toEmail, toName, subject, ctx, meta)
+}
+
+// createSmsLog is createEmailLog for texts: same attempted row, with channel=sms and toPhone.
+func createSmsLog(app core.App, e *core.RequestEvent, toPhone, toName string, ctx emailLogContext, meta map[string]any) (*core.Record, error) {
+	return createMessageLog(app, e, "sms", toPhone, toName, "", ctx, meta)
+}
+
+func createMessageLog(app core.App, e *core.RequestEvent, channel, to, toName, subject string, ctx emailLogContext, meta map[string]any) (*core.Record, error) {
 	coll, err := app.FindCollectionByNameOrId("email_logs")
 	if err != nil {
 		return nil, err
@@ -678,12 +679,16 @@ func createEmailLog(app *pocketbase.PocketBase, e *core.RequestEvent, toEmail, t
 
 	rec := core.NewRecord(coll)
 
-	rec.Set("channel", "email")
+	rec.Set("channel", channel)
 	rec.Set("status", "attempted")
 	rec.Set("sentAt", time.Now().Format(time.RFC3339))
 	rec.Set("error", "")
 
-	rec.Set("toEmail", strings.TrimSpace(toEmail))
+	if channel == "sms" {
+		rec.Set("toPhone", strings.TrimSpace(to))
+	} else {
+		rec.Set("toEmail", strings.TrimSpace(to))
+	}
 	rec.Set("toName", strings.TrimSpace(toName))
 	rec.Set("subject", strings.TrimSpace(subject))
 	rec.Set("templateKey", strings.TrimSpace(ctx.TemplateKey))
@@ -715,8 +720,8 @@ func createEmailLog(app *pocketbase.PocketBase, e *core.RequestEvent, toEmail, t
 		rec.Set("paperweightItemId", strings.TrimSpace(ctx.PaperweightItemId))
 	}
 
-	// sentBy (auth user)
-	if e != nil && e.Auth != nil && strings.TrimSpace(e.Auth.Id) != "" {
+	// sentBy (auth user); superusers aren't in the users collection the relation points at
+	if e != nil && e.Auth != nil && e.Auth.Collection().Name == "users" {
 		rec.Set("sentBy", e.Auth.Id)
 	}
 
@@ -732,8 +737,35 @@ func createEmailLog(app *pocketbase.PocketBase, e *core.RequestEvent, toEmail, t
 	return rec, nil
 }
 
+// mergeMeta returns a new map with patch applied on top of base.
+func mergeMeta(base map[string]any, patch map[string]any) map[string]any {
+	result := make(map[string]any, len(base)+len(patch))
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range patch {
+		result[k] = v
+	}
+	return result
+}
+
+// readEmailLogMeta returns a copy of the log's meta whether it was set in memory (a map) or
+// loaded from the database (raw JSON).
+func readEmailLogMeta(rec *core.Record) map[string]any {
+	meta := map[string]any{}
+	switch value := rec.Get("meta").(type) {
+	case map[string]any:
+		for k, v := range value {
+			meta[k] = v
+		}
+	default:
+		_ = rec.UnmarshalJSONField("meta", &meta)
+	}
+	return meta
+}
+
 // Updates status/error/meta on the email log. Never blocks main flow.
-func updateEmailLog(app *pocketbase.PocketBase, rec *core.Record, status string, errMsg string, metaPatch map[string]any) {
+func updateEmailLog(app core.App, rec *core.Record, status string, errMsg string, metaPatch map[string]any) {
 	if rec == nil {
 		return
 	}
@@ -749,10 +781,7 @@ func updateEmailLog(app *pocketbase.PocketBase, rec *core.Record, status string,
 	}
 
 	if metaPatch != nil {
-		existing, _ := rec.Get("meta").(map[string]any)
-		if existing == nil {
-			existing = map[string]any{}
-		}
+		existing := readEmailLogMeta(rec)
 		for k, v := range metaPatch {
 			existing[k] = v
 		}
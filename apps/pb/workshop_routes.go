@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	maxWorkshopFrames    = 500
+	defaultCapacityWeeks = 12
+	maxCapacityWeeks     = 52
+)
+
+// workshopFrame is one card on the workshop board.
+type workshopFrame struct {
+	FrameItemId      string            `json:"frameItemId"`
+	Label            string            `json:"label"`
+	Stage            string            `json:"stage"`
+	StageAt          string            `json:"stageAt"`
+	StageDates       map[string]string `json:"stageDates"`
+	SizeX            string            `json:"sizeX"`
+	SizeY            string            `json:"sizeY"`
+	FrameType        string            `json:"frameType"`
+	Layout           string            `json:"layout"`
+	PreservationType string            `json:"preservationType"`
+	PreservationDate string            `json:"preservationDate"`
+	OrderId          string            `json:"orderId"`
+	OrderNo          int               `json:"orderNo"`
+	OrderRef         string            `json:"orderRef"`
+	OrderStatus      string            `json:"orderStatus"`
+	OccasionDate     string            `json:"occasionDate"`
+	CustomerName     string            `json:"customerName"`
+}
+
+func registerWorkshopRoutes(se *core.ServeEvent, app *pocketbase.PocketBase) {
+	// every frame in ?stage=, soonest occasion first
+	se.Router.GET("/api/workshop/frames", func(e *core.RequestEvent) error {
+		return handleWorkshopFrames(app, e)
+	}).Bind(apis.RequireAuth())
+
+	// booked hours per week against WORKSHOP_CAPACITY_HOURS, from ?from= (default this week)
+	// for ?weeks= weeks
+	se.Router.GET("/api/workshop/capacity", func(e *core.RequestEvent) error {
+		return handleWorkshopCapacity(app, e)
+	}).Bind(apis.RequireAuth())
+}
+
+func handleWorkshopFrames(app core.App, e *core.RequestEvent) error {
+	stage := strings.TrimSpace(e.Request.URL.Query().Get("stage"))
+	if _, ok := frameStageDateFields[stage]; !ok {
+		return e.JSON(http.StatusBadRequest, map[string]any{
+			"ok":    false,
+			"error": fmt.Sprintf("Invalid stage %q (expected one of %s).", stage, strings.Join(frameStages, ", ")),
+		})
+	}
+
+	frames, err := findWorkshopFrames(app, stage)
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to load frame items.",
+			"details": err.Error(),
+		})
+	}
+	// one more than is returned is fetched, to tell a full board from a cut-off one
+	truncated := len(frames) > maxWorkshopFrames
+	if truncated {
+		frames = frames[:maxWorkshopFrames]
+	}
+
+	frameIds := make([]string, 0, len(frames))
+	for _, frame := range frames {
+		frameIds = append(frameIds, frame.Id)
+	}
+	orderByFrameId, err := fetchOrdersByFrameIds(app, frameIds)
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to load orders.",
+			"details": err.Error(),
+		})
+	}
+
+	orderIds := []string{}
+	seen := map[string]bool{}
+	for _, order := range orderByFrameId {
+		if !seen[order.Id] {
+			seen[order.Id] = true
+			orderIds = append(orderIds, order.Id)
+		}
+	}
+	customers, err := fetchRecordsByField(app, "customers", "orderId", orderIds)
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to load customers.",
+			"details": err.Error(),
+		})
+	}
+	customerNameByOrderId := map[string]string{}
+	for _, customer := range customers {
+		customerNameByOrderId[customer.GetString("orderId")] = strings.TrimSpace(
+			customer.GetString("firstName") + " " + customer.GetString("surname"),
+		)
+	}
+
+	items := make([]workshopFrame, 0, len(frames))
+	for _, frame := range frames {
+		item := workshopFrame{
+			FrameItemId:      frame.Id,
+			Stage:            stage,
+			StageAt:          frame.GetString(frameStageDateFields[stage]),
+			StageDates:       map[string]string{},
+			SizeX:            frame.GetString("sizeX"),
+			SizeY:            frame.GetString("sizeY"),
+			FrameType:        frame.GetString("frameType"),
+			Layout:           frame.GetString("layout"),
+			PreservationType: frame.GetString("preservationType"),
+			PreservationDate: frame.GetString("preservationDate"),
+		}
+		for _, s := range frameStages {
+			if at := frame.GetString(frameStageDateFields[s]); at != "" {
+				item.StageDates[s] = at
+			}
+		}
+
+		// frames not (yet) attached to an order still show, so nothing goes missing from the board
+		if order, ok := orderByFrameId[frame.Id]; ok {
+			if i := slices.Index(order.GetStringSlice("frameOrderId"), frame.Id); i >= 0 {
+				item.Label = fmt.Sprintf("Item %d", i+1)
+			}
+			item.OrderId = order.Id
+			item.OrderNo = order.GetInt("orderNo")
+			item.OrderRef = order.GetString("orderRef")
+			item.OrderStatus = order.GetString("orderStatus")
+			item.OccasionDate = order.GetString("occasionDate")
+			item.CustomerName = customerNameByOrderId[order.Id]
+		}
+
+		items = append(items, item)
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{
+		"ok":        true,
+		"stage":     stage,
+		"total":     len(items),
+		"truncated": truncated,
+		"items":     items,
+	})
+}
+
+// workshopFrameOccasionDate is the occasionDate of the order listing the frame, or "".
+const workshopFrameOccasionDate = "COALESCE((SELECT [[orders.occasionDate]] FROM {{orders}}, json_each([[orders.frameOrderId]]) WHERE json_each.value = [[order_frame_items.id]] LIMIT 1), '')"
+
+// findWorkshopFrames loads up to maxWorkshopFrames+1 frames in stage, soonest occasion first.
+// Undated occasions (and frames not on an order) go last, then whatever has waited longest in
+// the stage.
+func findWorkshopFrames(app core.App, stage string) ([]*core.Record, error) {
+	frames := []*core.Record{}
+	err := app.RecordQuery("order_frame_items").
+		AndWhere(dbx.HashExp{"stage": stage}).
+		OrderBy(
+			workshopFrameOccasionDate+" = '' ASC",
+			workshopFrameOccasionDate+" ASC",
+			frameStageDateFields[stage]+" ASC",
+		).
+		Limit(maxWorkshopFrames + 1).
+		All(&frames)
+	return frames, err
+}
+
+// fetchOrdersByFrameIds maps each frame id to the order that lists it in frameOrderId.
+func fetchOrdersByFrameIds(app core.App, frameIds []string) (map[string]*core.Record, error) {
+	result := map[string]*core.Record{}
+	for start := 0; start < len(frameIds); start += filterChunkSize {
+		chunk := frameIds[start:min(start+filterChunkSize, len(frameIds))]
+
+		conds := make([]string, 0, len(chunk))
+		params := dbx.Params{}
+		for i, id := range chunk {
+			key := fmt.Sprintf("frame%d", i)
+			conds = append(conds, fmt.Sprintf("frameOrderId ~ {:%s}", key))
+			params[key] = id
+		}
+
+		orders, err := app.FindRecordsByFilter("orders", strings.Join(conds, " || "), "", 0, 0, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, order := range orders {
+			for _, frameId := range order.GetStringSlice("frameOrderId") {
+				result[frameId] = order
+			}
+		}
+	}
+	return result, nil
+}
+
+func handleWorkshopCapacity(app core.App, e *core.RequestEvent) error {
+	query := e.Request.URL.Query()
+
+	from := time.Now()
+	if raw := strings.TrimSpace(query.Get("from")); raw != "" {
+		parsed, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return e.JSON(http.StatusBadRequest, map[string]any{
+				"ok":    false,
+				"error": fmt.Sprintf("Invalid date format: %s (expected YYYY-MM-DD)", raw),
+			})
+		}
+		from = parsed
+	}
+
+	count := defaultCapacityWeeks
+	if raw := strings.TrimSpace(query.Get("weeks")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxCapacityWeeks {
+			return e.JSON(http.StatusBadRequest, map[string]any{
+				"ok":    false,
+				"error": fmt.Sprintf("weeks must be between 1 and %d.", maxCapacityWeeks),
+			})
+		}
+		count = parsed
+	}
+
+	settings := resolveCapacitySettings(app)
+	load, err := projectWorkshopLoad(app, settings)
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, map[string]any{
+			"ok":      false,
+			"error":   "Failed to load orders.",
+			"details": err.Error(),
+		})
+	}
+
+	// unframed work on occasions before the first week is already late, so report it apart
+	firstWeek := workshopWeekStart(from)
+	backlogHours, backlogFrames := 0.0, 0
+	for start, week := range load {
+		if start.Before(firstWeek) {
+			backlogHours += week.Hours
+			backlogFrames += week.Frames
+		}
+	}
+
+	weeks := capacityWeeks(load, settings, from, count)
+	overbooked := []string{}
+	for _, week := range weeks {
+		if week.Overbooked {
+			overbooked = append(overbooked, week.WeekStart)
+		}
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{
+		"ok":              true,
+		"capacityHours":   settings.WeeklyHours,
+		"overbookedWeeks": overbooked,
+		"backlog": map[string]any{
+			"hours":  roundHours(backlogHours),
+			"frames": backlogFrames,
+		},
+		"weeks": weeks,
+	})
+}